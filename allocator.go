@@ -0,0 +1,335 @@
+package swole
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultAllocationInterval is how often the results used by the allocators are refreshed
+const DefaultAllocationInterval = time.Minute
+
+// DefaultEpsilon is the share of participants an EpsilonGreedy allocator uses to explore
+const DefaultEpsilon = 0.1
+
+var (
+	_ WeightedAllocator = WeightedRandom{}
+	_ IdentityAllocator = HashAllocator{}
+	_ WeightedAllocator = HashAllocator{}
+	_ WeightedAllocator = (*RoundRobin)(nil)
+	_ Allocator         = ThompsonSampling{}
+	_ Allocator         = EpsilonGreedy{}
+)
+
+// AllocationContext is what an Allocator knows about a new participant
+type AllocationContext struct {
+	Experiment Experiment
+	// Subject is the identity of the participant when it is known. It is always set for
+	// allocators that implement IdentityAllocator
+	Subject string
+	// Request is the request of the participant, it is nil for the context based api
+	Request *http.Request
+	// Results returns the results of the experiment, refreshed every AllocationInterval. They are
+	// only read when needed and are nil when they cannot be read
+	Results func() *ExperimentResults
+}
+
+func (a AllocationContext) results() *ExperimentResults {
+	if a.Results == nil {
+		return nil
+	}
+
+	return a.Results()
+}
+
+// Allocator chooses the alternative of the new participants of an experiment. Participants
+// that were already assigned keep their alternative, so allocators only shift the traffic
+// of the participants that are yet to come. Alternatives that do not belong to the
+// experiment are replaced by a weighted random one
+type Allocator interface {
+	Allocate(ctx context.Context, allocation AllocationContext) string
+}
+
+// IdentityAllocator is an Allocator that needs the identity of every participant, the manager
+// creates one for participants that do not have it yet like it does for layered experiments
+type IdentityAllocator interface {
+	Allocator
+	RequiresIdentity() bool
+}
+
+// WeightedAllocator is an Allocator that splits the participants according to the weights of
+// the alternatives. Only the split of the participants of experiments allocated by one is
+// checked, the other allocators are expected to drift from the weights
+type WeightedAllocator interface {
+	Allocator
+	FollowsWeights() bool
+}
+
+// followsWeights reports whether the participants allocated by the allocator are split
+// according to the weights, nil is the default weighted random allocation
+func followsWeights(allocator Allocator) bool {
+	if allocator == nil {
+		return true
+	}
+	a, ok := allocator.(WeightedAllocator)
+
+	return ok && a.FollowsWeights()
+}
+
+// WeightedRandom allocates the participants at random according to the weights of the
+// alternatives, it is the default allocator
+type WeightedRandom struct{}
+
+func (WeightedRandom) Allocate(ctx context.Context, allocation AllocationContext) string {
+	return allocation.Experiment.chooseAlternative()
+}
+
+func (WeightedRandom) FollowsWeights() bool {
+	return true
+}
+
+// HashAllocator allocates the participants according to the weights by hashing their identity,
+// so a participant always gets the same alternative even if the assignment is lost
+type HashAllocator struct {
+	// Seed changes the allocation of the participants, it defaults to the key of the experiment
+	Seed string
+}
+
+func (h HashAllocator) Allocate(ctx context.Context, allocation AllocationContext) string {
+	experiment := allocation.Experiment
+	if len(allocation.Subject) == 0 {
+		return experiment.chooseAlternative()
+	}
+
+	seed := h.Seed
+	if len(seed) == 0 {
+		seed = experiment.Key
+	}
+
+	return experiment.alternativeAt(bucket(seed, allocation.Subject, experiment.totalWeight()))
+}
+
+func (HashAllocator) RequiresIdentity() bool {
+	return true
+}
+
+func (HashAllocator) FollowsWeights() bool {
+	return true
+}
+
+// RoundRobin allocates the participants to the alternatives in turn, respecting their weights.
+// It makes every alternative show up quickly, which is mostly useful in QA environments
+type RoundRobin struct {
+	mu   sync.Mutex
+	next map[string]int
+}
+
+func (rr *RoundRobin) Allocate(ctx context.Context, allocation AllocationContext) string {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	experiment := allocation.Experiment
+	if rr.next == nil {
+		rr.next = make(map[string]int)
+	}
+	position := rr.next[experiment.Key] % experiment.totalWeight()
+	rr.next[experiment.Key] = position + 1
+
+	return experiment.alternativeAt(position)
+}
+
+func (rr *RoundRobin) FollowsWeights() bool {
+	return true
+}
+
+// ThompsonSampling is a multi-armed bandit that samples the conversion rate of every alternative
+// from its Beta posterior and allocates the participant to the highest one. Alternatives that
+// convert better get more traffic while the uncertain ones keep being explored
+type ThompsonSampling struct{}
+
+func (ThompsonSampling) Allocate(ctx context.Context, allocation AllocationContext) string {
+	results := allocation.results()
+	if results == nil {
+		return allocation.Experiment.chooseAlternative()
+	}
+
+	best, bestSample := "", -1.0
+	for _, a := range results.Alternatives {
+		sample := betaSample(float64(1+a.Completions), float64(1+max(a.Participants-a.Completions, 0)))
+		if sample > bestSample {
+			best, bestSample = a.Name, sample
+		}
+	}
+
+	return best
+}
+
+// EpsilonGreedy is a multi-armed bandit that allocates Epsilon of the participants according
+// to the weights of the alternatives and the rest to the one with the best conversion rate.
+// Alternatives that tie for the best rate share the traffic
+type EpsilonGreedy struct {
+	// Epsilon is the share of participants used to explore, between 0 and 1. Zero means
+	// DefaultEpsilon and a negative value never explores
+	Epsilon float64
+}
+
+func (g EpsilonGreedy) Allocate(ctx context.Context, allocation AllocationContext) string {
+	epsilon := g.Epsilon
+	if epsilon == 0 {
+		epsilon = DefaultEpsilon
+	}
+	if rand.Float64() < epsilon {
+		return allocation.Experiment.chooseAlternative()
+	}
+
+	results := allocation.results()
+	if results == nil {
+		return allocation.Experiment.chooseAlternative()
+	}
+
+	best, bestRate, ties := "", -1.0, 0
+	for _, a := range results.Alternatives {
+		rate := a.ConversionRate()
+		switch {
+		case rate > bestRate:
+			best, bestRate, ties = a.Name, rate, 1
+		case rate == bestRate:
+			// every tied alternative ends up being the best with the same probability
+			ties++
+			if rand.IntN(ties) == 0 {
+				best = a.Name
+			}
+		}
+	}
+
+	return best
+}
+
+// experimentAllocator returns the allocator of the experiment, or the one of the manager
+func (m *ExperimentManager) experimentAllocator(experiment Experiment) Allocator {
+	if experiment.Allocator != nil {
+		return experiment.Allocator
+	}
+
+	return m.Allocator
+}
+
+// allocate chooses the alternative of a new participant with the allocator of the experiment,
+// or the one of the manager
+func (m *ExperimentManager) allocate(ctx context.Context, experiment Experiment, p participant) string {
+	allocator := m.experimentAllocator(experiment)
+	if allocator == nil {
+		return experiment.chooseAlternative()
+	}
+
+	allocation := AllocationContext{
+		Experiment: experiment,
+		Subject:    p.knownIdentity(),
+		Request:    p.request(),
+		Results: func() *ExperimentResults {
+			return m.allocationResults(ctx, experiment.Key)
+		},
+	}
+	if a, ok := allocator.(IdentityAllocator); ok && a.RequiresIdentity() && len(allocation.Subject) == 0 {
+		// without an identity the allocator falls back to its own default
+		allocation.Subject, _ = p.identity()
+	}
+
+	alternative := allocator.Allocate(ctx, allocation)
+	if !experiment.hasAlternative(alternative) {
+		return experiment.chooseAlternative()
+	}
+
+	return alternative
+}
+
+// allocationResults returns the results of the experiment, refreshed every AllocationInterval
+func (m *ExperimentManager) allocationResults(ctx context.Context, key string) *ExperimentResults {
+	interval := m.AllocationInterval
+	if interval == 0 {
+		interval = DefaultAllocationInterval
+	}
+
+	now := m.Now()
+	if results, found := m.allocations.get(key, now, interval); found {
+		return results
+	}
+
+	results, err := m.GetResults(key)
+	if err != nil && m.Logger != nil {
+		m.Logger.LogAttrs(ctx, slog.LevelError, "swole: cannot refresh the results of the allocator",
+			slog.String(AttributeExperiment, key),
+			slog.Any("error", err),
+		)
+	}
+	// failures are cached as well so that a broken tracking store is not hit by every participant
+	m.allocations.set(key, results, now)
+
+	return results
+}
+
+type allocationEntry struct {
+	results   *ExperimentResults
+	refreshed time.Time
+}
+
+// allocationCache keeps the results used by the allocators keyed by experiment
+type allocationCache struct {
+	mu      sync.Mutex
+	entries map[string]allocationEntry
+}
+
+func (c *allocationCache) get(key string, now time.Time, interval time.Duration) (*ExperimentResults, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, found := c.entries[key]
+	if !found || now.Sub(entry.refreshed) >= interval {
+		return nil, false
+	}
+
+	return entry.results, true
+}
+
+func (c *allocationCache) set(key string, results *ExperimentResults, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]allocationEntry)
+	}
+	c.entries[key] = allocationEntry{results: results, refreshed: now}
+}
+
+// betaSample draws from the Beta(a, b) distribution
+func betaSample(a, b float64) float64 {
+	x := gammaSample(a)
+	y := gammaSample(b)
+
+	return x / (x + y)
+}
+
+// gammaSample draws from the Gamma(shape, 1) distribution with the method of Marsaglia and Tsang,
+// shape must be at least 1 which is always the case for the Beta posteriors of the conversions
+func gammaSample(shape float64) float64 {
+	d := shape - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+
+	for {
+		x := rand.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+
+		u := rand.Float64()
+		if math.Log(u) < 0.5*x*x+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package swole
+
+import (
+	"context"
+)
+
+// AssignmentStore persists the assignments of subjects independently of any transport,
+// it is used by the context based api of the ExperimentManager
+type AssignmentStore interface {
+	// ExperimentExists reports whether the subject started the experiment, along with the
+	// alternative and the experiment version it was assigned under
+	ExperimentExists(ctx context.Context, subject, key string) (exists bool, alternative string, version int, err error)
+	// PersistExperiment stores a fresh assignment, any previous finish of the experiment is cleared
+	PersistExperiment(ctx context.Context, subject, key, alternative string, version int) (err error)
+	// RefreshTtl extends the lifetime of the assignments of the subject, if the store expires them
+	RefreshTtl(ctx context.Context, subject string) (err error)
+	ExperimentFinish(ctx context.Context, subject, key string) (finishFirstTime bool, err error)
+	// Reset drops every assignment of the subject, it is used to recover from a corrupted state
+	Reset(ctx context.Context, subject string) (err error)
+}
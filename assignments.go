@@ -0,0 +1,97 @@
+package swole
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Assignments maps experiment keys to the alternative the subject was assigned
+type Assignments map[string]string
+
+type assignmentsContextKey struct{}
+
+// WithAssignments returns a copy of the context that carries the assignments,
+// merged with any assignments the context already carries
+func WithAssignments(ctx context.Context, assignments Assignments) context.Context {
+	merged := AssignmentsFromContext(ctx)
+	if merged == nil {
+		merged = make(Assignments, len(assignments))
+	}
+	maps.Copy(merged, assignments)
+
+	return context.WithValue(ctx, assignmentsContextKey{}, merged)
+}
+
+// AssignmentsFromContext returns a copy of the assignments carried by the context
+func AssignmentsFromContext(ctx context.Context) Assignments {
+	assignments, _ := ctx.Value(assignmentsContextKey{}).(Assignments)
+
+	return maps.Clone(assignments)
+}
+
+// Encode formats the assignments as a W3C baggage list, `key=alternative,other_key=alternative`.
+// Keys and alternatives are percent encoded so they can contain any character
+func (a Assignments) Encode() string {
+	members := make([]string, 0, len(a))
+	for _, key := range slices.Sorted(maps.Keys(a)) {
+		members = append(members, escapeBaggage(key)+"="+escapeBaggage(a[key]))
+	}
+
+	return strings.Join(members, ",")
+}
+
+// ParseAssignments parses assignments formatted by Encode. Empty members and member
+// properties are ignored as the W3C baggage specification requires
+func ParseAssignments(value string) (Assignments, error) {
+	assignments := make(Assignments)
+
+	for member := range strings.SplitSeq(value, ",") {
+		// properties are not used by swole
+		member, _, _ = strings.Cut(member, ";")
+		member = strings.TrimSpace(member)
+		if len(member) == 0 {
+			continue
+		}
+
+		rawKey, rawAlternative, found := strings.Cut(member, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid assignment `%s`", member)
+		}
+
+		key, err := url.PathUnescape(strings.TrimSpace(rawKey))
+		if err != nil {
+			return nil, err
+		}
+		alternative, err := url.PathUnescape(strings.TrimSpace(rawAlternative))
+		if err != nil {
+			return nil, err
+		}
+		if len(key) == 0 || len(alternative) == 0 {
+			return nil, fmt.Errorf("invalid assignment `%s`", member)
+		}
+
+		assignments[key] = alternative
+	}
+
+	return assignments, nil
+}
+
+// escapeBaggage percent encodes everything but the unreserved characters, which are
+// valid both in baggage keys and values
+func escapeBaggage(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+
+	return b.String()
+}
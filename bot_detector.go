@@ -0,0 +1,71 @@
+package swole
+
+import (
+	"net/http"
+	"strings"
+)
+
+// KnownCrawlers are User-Agent fragments of common bots, crawlers and http clients. They are
+// specific enough not to match browsers, a bare "bot" would also match devices like the CUBOT phones
+var KnownCrawlers = []string{
+	"bot/",
+	"bot.htm",
+	"+http",
+	"googlebot",
+	"bingbot",
+	"duckduckbot",
+	"yandexbot",
+	"baiduspider",
+	"slurp",
+	"facebookexternalhit",
+	"mediapartners-google",
+	"adsbot-google",
+	"crawler",
+	"spider/",
+	"headlesschrome",
+	"chrome-lighthouse",
+	"curl/",
+	"wget/",
+	"python-requests",
+	"go-http-client",
+}
+
+// BotDetector decides whether a request was made by a bot. Bots are served the first
+// alternative of every experiment without being enrolled, only their exclusion is counted
+type BotDetector interface {
+	IsBot(r *http.Request) bool
+}
+
+// UserAgentBotDetector matches the User-Agent of the request against a list of patterns
+type UserAgentBotDetector struct {
+	// Patterns are matched case insensitively anywhere in the User-Agent
+	Patterns []string
+	// Predicate is an optional custom check that runs after the patterns
+	Predicate func(r *http.Request) bool
+}
+
+// NewBotDetector creates a detector that matches the known crawlers
+func NewBotDetector() *UserAgentBotDetector {
+	patterns := make([]string, len(KnownCrawlers))
+	copy(patterns, KnownCrawlers)
+
+	return &UserAgentBotDetector{
+		Patterns: patterns,
+	}
+}
+
+func (d *UserAgentBotDetector) IsBot(r *http.Request) bool {
+	userAgent := strings.ToLower(r.UserAgent())
+
+	for _, pattern := range d.Patterns {
+		if strings.Contains(userAgent, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+
+	if d.Predicate != nil {
+		return d.Predicate(r)
+	}
+
+	return false
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/antonisgkamitsios/swole"
+)
+
+func runCookie(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("expected `decode` or `encode`")
+	}
+
+	switch args[0] {
+	case "decode":
+		return runCookieDecode(args[1:], stdin, stdout)
+	case "encode":
+		return runCookieEncode(args[1:], stdin, stdout)
+	default:
+		return fmt.Errorf("unknown action `%s`, expected `decode` or `encode`", args[0])
+	}
+}
+
+func secretFlag(fs *flag.FlagSet) *string {
+	return fs.String("secret", os.Getenv("SWOLE_SECRET"), "secret of the CookiePersistenceStore, defaults to $SWOLE_SECRET")
+}
+
+func runCookieDecode(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("cookie decode", flag.ContinueOnError)
+	secret := secretFlag(fs)
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var value string
+	switch fs.NArg() {
+	case 0:
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+		value = string(data)
+	case 1:
+		value = fs.Arg(0)
+	default:
+		return errors.New("expected a single cookie value")
+	}
+	// the value can be copied with or without the name of the cookie
+	value = strings.TrimPrefix(strings.TrimSpace(value), "swole=")
+
+	assignments, signed, err := swole.DecodeCookieValue(value, []byte(*secret))
+	if err != nil {
+		return err
+	}
+
+	signature := "none"
+	switch {
+	case signed && len(*secret) > 0:
+		signature = "verified"
+	case signed:
+		signature = "not verified, pass -secret to verify it"
+	}
+
+	if *asJSON {
+		return writeJSON(stdout, struct {
+			Signed      bool                    `json:"signed"`
+			Verified    bool                    `json:"verified"`
+			Assignments swole.CookieAssignments `json:"assignments"`
+		}{signed, signed && len(*secret) > 0, assignments})
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "signature:\t%s\n", signature)
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "EXPERIMENT\tALTERNATIVE\tVERSION\tFINISHED")
+	for _, key := range slices.Sorted(maps.Keys(assignments)) {
+		a := assignments[key]
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%t\n", key, a.Alternative, a.Version, a.Finished)
+	}
+
+	return tw.Flush()
+}
+
+func runCookieEncode(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("cookie encode", flag.ContinueOnError)
+	secret := secretFlag(fs)
+	version := fs.Int("version", 0, "version of the assignments given as arguments")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: swole cookie encode [flags] [experiment=alternative ...]")
+		fmt.Fprintln(fs.Output(), "without arguments the assignments are read from stdin as printed by `swole cookie decode -json`")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	assignments := make(swole.CookieAssignments)
+	if fs.NArg() == 0 {
+		var decoded struct {
+			Assignments swole.CookieAssignments `json:"assignments"`
+		}
+		err := json.NewDecoder(stdin).Decode(&decoded)
+		if err != nil {
+			return fmt.Errorf("cannot decode assignments: %w", err)
+		}
+		assignments = decoded.Assignments
+	}
+	for _, arg := range fs.Args() {
+		key, alternative, found := strings.Cut(arg, "=")
+		if !found || len(key) == 0 || len(alternative) == 0 {
+			return fmt.Errorf("invalid assignment `%s`, expected experiment=alternative", arg)
+		}
+		assignments[key] = swole.CookieAssignment{Alternative: alternative, Version: *version}
+	}
+
+	value, err := swole.EncodeCookieValue(assignments, []byte(*secret))
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(stdout, value)
+	return nil
+}
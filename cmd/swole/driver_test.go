@@ -0,0 +1,107 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+func init() {
+	sql.Register("swoletest", &tableDriver{tables: make(map[string]map[string]string)})
+}
+
+// tableDriver is a database/sql driver holding an experiments table for every data source
+// name, it understands only the statements of the SQLExperimentStore
+type tableDriver struct {
+	mu     sync.Mutex
+	tables map[string]map[string]string
+}
+
+func (d *tableDriver) Open(name string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, found := d.tables[name]; !found {
+		d.tables[name] = make(map[string]string)
+	}
+
+	return &tableConn{driver: d, rows: d.tables[name]}, nil
+}
+
+type tableConn struct {
+	driver *tableDriver
+	rows   map[string]string
+}
+
+func (c *tableConn) Close() error              { return nil }
+func (c *tableConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *tableConn) Commit() error             { return nil }
+func (c *tableConn) Rollback() error           { return nil }
+
+func (c *tableConn) Prepare(query string) (driver.Stmt, error) {
+	return &tableStmt{conn: c, query: query}, nil
+}
+
+type tableStmt struct {
+	conn  *tableConn
+	query string
+}
+
+func (s *tableStmt) Close() error  { return nil }
+func (s *tableStmt) NumInput() int { return strings.Count(s.query, "?") }
+
+func (s *tableStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.conn.driver.mu.Lock()
+	defer s.conn.driver.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(s.query, "CREATE TABLE"):
+	case strings.HasPrefix(s.query, "DELETE"):
+		delete(s.conn.rows, args[0].(string))
+	case strings.HasPrefix(s.query, "INSERT"):
+		s.conn.rows[args[0].(string)] = args[1].(string)
+	default:
+		return nil, fmt.Errorf("unexpected statement: %s", s.query)
+	}
+
+	return driver.RowsAffected(1), nil
+}
+
+func (s *tableStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.conn.driver.mu.Lock()
+	defer s.conn.driver.mu.Unlock()
+
+	var definitions []string
+	if len(args) > 0 {
+		if definition, found := s.conn.rows[args[0].(string)]; found {
+			definitions = append(definitions, definition)
+		}
+	} else {
+		for _, key := range slices.Sorted(maps.Keys(s.conn.rows)) {
+			definitions = append(definitions, s.conn.rows[key])
+		}
+	}
+
+	return &tableRows{definitions: definitions}, nil
+}
+
+type tableRows struct {
+	definitions []string
+}
+
+func (r *tableRows) Columns() []string { return []string{"definition"} }
+func (r *tableRows) Close() error      { return nil }
+
+func (r *tableRows) Next(dest []driver.Value) error {
+	if len(r.definitions) == 0 {
+		return io.EOF
+	}
+	dest[0], r.definitions = r.definitions[0], r.definitions[1:]
+
+	return nil
+}
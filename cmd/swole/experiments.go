@@ -0,0 +1,560 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/antonisgkamitsios/swole"
+)
+
+// storeFlags are shared by every command that works with the experiment store, which is a
+// SQLExperimentStore when a driver is set and a FileExperimentStore otherwise
+type storeFlags struct {
+	fs     *flag.FlagSet
+	store  *string
+	driver *string
+	dsn    *string
+	json   *bool
+}
+
+func newStoreFlags(name string) storeFlags {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	store := os.Getenv("SWOLE_STORE")
+	if len(store) == 0 {
+		store = "experiments.json"
+	}
+
+	return storeFlags{
+		fs:     fs,
+		store:  fs.String("store", store, "JSON file of a FileExperimentStore, defaults to $SWOLE_STORE"),
+		driver: fs.String("driver", os.Getenv("SWOLE_DRIVER"), "database/sql driver of a SQLExperimentStore, it must be imported by the binary, defaults to $SWOLE_DRIVER"),
+		dsn:    fs.String("dsn", os.Getenv("SWOLE_DSN"), "data source name of the SQLExperimentStore, defaults to $SWOLE_DSN"),
+		json:   fs.Bool("json", false, "print JSON instead of a table"),
+	}
+}
+
+// parse parses the flags and returns between min and max positional arguments, which
+// may come before or after the flags so that both `swole show <key> -json` and
+// `swole show -json <key>` work
+func (f storeFlags) parse(args []string, min, max int) ([]string, error) {
+	var values []string
+	for {
+		for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+			values = append(values, args[0])
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			break
+		}
+
+		if err := f.fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = f.fs.Args()
+	}
+
+	if len(values) < min || len(values) > max {
+		return nil, fmt.Errorf("unexpected arguments: %q", values)
+	}
+
+	return values, nil
+}
+
+// dollarPlaceholders are the drivers whose queries number their arguments like PostgreSQL
+var dollarPlaceholders = []string{"postgres", "pgx"}
+
+// experimentStore opens the experiment store, release closes it
+func (f storeFlags) experimentStore() (store swole.ExperimentStore, release func() error, err error) {
+	if len(*f.driver) == 0 {
+		return swole.NewFileExperimentStore(*f.store), func() error { return nil }, nil
+	}
+
+	if !slices.Contains(sql.Drivers(), *f.driver) {
+		return nil, nil, fmt.Errorf("unknown driver `%s`, the binary has %q", *f.driver, sql.Drivers())
+	}
+	db, err := sql.Open(*f.driver, *f.dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sqlStore := swole.NewSQLExperimentStore(db)
+	if slices.Contains(dollarPlaceholders, *f.driver) {
+		sqlStore.Placeholder = func(n int) string { return "$" + strconv.Itoa(n) }
+	}
+	err = sqlStore.CreateTable()
+	if err != nil {
+		return nil, nil, errors.Join(err, db.Close())
+	}
+
+	return sqlStore, db.Close, nil
+}
+
+func writeJSON(w io.Writer, value any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(value)
+}
+
+func status(e swole.Experiment, now time.Time) string {
+	switch {
+	case e.Paused:
+		return "paused"
+	case !e.StartsAt.IsZero() && now.Before(e.StartsAt):
+		return "scheduled"
+	case !e.EndsAt.IsZero() && !now.Before(e.EndsAt):
+		return "ended"
+	default:
+		return "running"
+	}
+}
+
+func getExperiment(store swole.ExperimentStore, key string) (swole.Experiment, error) {
+	experiment, found, err := store.Get(key)
+	if err != nil {
+		return swole.Experiment{}, err
+	}
+	if !found {
+		return swole.Experiment{}, fmt.Errorf("experiment `%s` not found", key)
+	}
+
+	return experiment, nil
+}
+
+// updateExperiment applies the change to a stored experiment and saves it if it is still valid
+func updateExperiment(args []string, name string, extra int, stdout io.Writer, change func(e *swole.Experiment, args []string) error) error {
+	f := newStoreFlags(name)
+	values, err := f.parse(args, 1+extra, 1+extra)
+	if err != nil {
+		return err
+	}
+
+	store, release, err := f.experimentStore()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	experiment, err := getExperiment(store, values[0])
+	if err != nil {
+		return err
+	}
+
+	err = change(&experiment, values[1:])
+	if err != nil {
+		return err
+	}
+
+	err = experiment.Validate()
+	if err != nil {
+		return err
+	}
+
+	err = store.Set(experiment.Key, experiment)
+	if err != nil {
+		return err
+	}
+
+	return printExperiment(stdout, experiment, *f.json)
+}
+
+// bumpVersion records a change of the experiment with the policy for the existing participants
+func bumpVersion(e *swole.Experiment, policy swole.VersionPolicy) {
+	e.Version++
+	if e.VersionPolicies == nil {
+		e.VersionPolicies = make(map[int]swole.VersionPolicy)
+	}
+	e.VersionPolicies[e.Version] = policy
+}
+
+func printExperiment(w io.Writer, e swole.Experiment, asJSON bool) error {
+	if asJSON {
+		return writeJSON(w, e)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "key:\t%s\n", e.Key)
+	fmt.Fprintf(tw, "status:\t%s\n", status(e, time.Now()))
+	fmt.Fprintf(tw, "version:\t%d\n", e.Version)
+	if len(e.Layer) > 0 {
+		fmt.Fprintf(tw, "layer:\t%s [%d, %d)\n", e.Layer, e.Buckets.Start, e.Buckets.End)
+	}
+	if !e.StartsAt.IsZero() {
+		fmt.Fprintf(tw, "starts at:\t%s\n", e.StartsAt.Format(time.RFC3339))
+	}
+	if !e.EndsAt.IsZero() {
+		fmt.Fprintf(tw, "ends at:\t%s\n", e.EndsAt.Format(time.RFC3339))
+	}
+	if len(e.Winner) > 0 {
+		fmt.Fprintf(tw, "winner:\t%s\n", e.Winner)
+	}
+	if e.MaxParticipants > 0 {
+		fmt.Fprintf(tw, "max participants:\t%d\n", e.MaxParticipants)
+	}
+	if len(e.Factors) > 0 {
+		for _, f := range e.Factors {
+			fmt.Fprintln(tw)
+			fmt.Fprintf(tw, "FACTOR %s\tWEIGHT\tPAYLOAD\n", f.Key)
+			for _, a := range f.Alternatives {
+				fmt.Fprintf(tw, "%s\t%d\t%s\n", a.Name, a.Weight, string(a.Payload))
+			}
+		}
+		return tw.Flush()
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "ALTERNATIVE\tWEIGHT\tPAYLOAD")
+	for _, a := range e.Alternatives {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", a.Name, a.Weight, string(a.Payload))
+	}
+
+	return tw.Flush()
+}
+
+func runList(args []string, stdin io.Reader, stdout io.Writer) error {
+	f := newStoreFlags("list")
+	_, err := f.parse(args, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	store, release, err := f.experimentStore()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	experiments, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	if *f.json {
+		return writeJSON(stdout, experiments)
+	}
+
+	now := time.Now()
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tSTATUS\tVERSION\tALTERNATIVES")
+	for _, e := range experiments {
+		alternatives := e.ServedAlternatives()
+		names := make([]string, 0, len(alternatives))
+		for _, a := range alternatives {
+			names = append(names, a.Name)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", e.Key, status(e, now), e.Version, strings.Join(names, ","))
+	}
+
+	return tw.Flush()
+}
+
+func runShow(args []string, stdin io.Reader, stdout io.Writer) error {
+	f := newStoreFlags("show")
+	values, err := f.parse(args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	store, release, err := f.experimentStore()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	experiment, err := getExperiment(store, values[0])
+	if err != nil {
+		return err
+	}
+
+	return printExperiment(stdout, experiment, *f.json)
+}
+
+func runCreate(args []string, stdin io.Reader, stdout io.Writer) error {
+	f := newStoreFlags("create")
+	alternatives := f.fs.String("alternatives", "", "comma separated alternatives, the first one is the control")
+	weights := f.fs.String("weights", "", "comma separated weights of the alternatives, defaults to equal weights")
+	file := f.fs.String("f", "", "create the experiment from a JSON file instead, - reads stdin")
+	values, err := f.parse(args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	var experiment swole.Experiment
+	switch {
+	case len(*file) > 0:
+		in := stdin
+		if *file != "-" {
+			fh, err := os.Open(*file)
+			if err != nil {
+				return err
+			}
+			defer fh.Close()
+			in = fh
+		}
+		err = json.NewDecoder(in).Decode(&experiment)
+		if err != nil {
+			return fmt.Errorf("cannot decode experiment: %w", err)
+		}
+	case len(values) == 1:
+		experiment.Key = values[0]
+		for _, name := range splitList(*alternatives) {
+			experiment.Alternatives = append(experiment.Alternatives, swole.Alternative{Name: name})
+		}
+		err = setWeights(&experiment, splitList(*weights))
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.New("expected the key of the experiment or -f")
+	}
+
+	err = experiment.Validate()
+	if err != nil {
+		return err
+	}
+
+	store, release, err := f.experimentStore()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	_, found, err := store.Get(experiment.Key)
+	if err != nil {
+		return err
+	}
+	if found {
+		return fmt.Errorf("experiment `%s` already exists", experiment.Key)
+	}
+
+	err = store.Set(experiment.Key, experiment)
+	if err != nil {
+		return err
+	}
+
+	return printExperiment(stdout, experiment, *f.json)
+}
+
+// setWeights sets the weights of the alternatives in order, an empty list keeps them. The
+// weights of a multivariate experiment are the ones of the alternatives of every factor in
+// order, its combinations are weighted by them
+func setWeights(e *swole.Experiment, weights []string) error {
+	if len(weights) == 0 {
+		return nil
+	}
+
+	var alternatives []*swole.Alternative
+	for i := range e.Factors {
+		for j := range e.Factors[i].Alternatives {
+			alternatives = append(alternatives, &e.Factors[i].Alternatives[j])
+		}
+	}
+	if len(e.Factors) == 0 {
+		for i := range e.Alternatives {
+			alternatives = append(alternatives, &e.Alternatives[i])
+		}
+	}
+	if len(weights) != len(alternatives) {
+		return fmt.Errorf("expected %d weights, got %d", len(alternatives), len(weights))
+	}
+
+	for i, value := range weights {
+		weight, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid weight `%s`", value)
+		}
+		alternatives[i].Weight = weight
+	}
+	if len(e.Factors) > 0 {
+		// the combinations are generated again from the new weights
+		e.Alternatives = nil
+	}
+
+	return nil
+}
+
+func runSetWeights(args []string, stdin io.Reader, stdout io.Writer) error {
+	return updateExperiment(args, "set-weights", 1, stdout, func(e *swole.Experiment, args []string) error {
+		err := setWeights(e, splitList(args[0]))
+		if err != nil {
+			return err
+		}
+		bumpVersion(e, swole.KeepAssignments)
+
+		return nil
+	})
+}
+
+func runPause(args []string, stdin io.Reader, stdout io.Writer) error {
+	return updateExperiment(args, "pause", 0, stdout, func(e *swole.Experiment, args []string) error {
+		e.Paused = true
+		return nil
+	})
+}
+
+func runResume(args []string, stdin io.Reader, stdout io.Writer) error {
+	return updateExperiment(args, "resume", 0, stdout, func(e *swole.Experiment, args []string) error {
+		e.Paused = false
+		return nil
+	})
+}
+
+func runDeclareWinner(args []string, stdin io.Reader, stdout io.Writer) error {
+	return updateExperiment(args, "declare-winner", 1, stdout, func(e *swole.Experiment, args []string) error {
+		e.Winner = args[0]
+
+		// the experiment ends right away so that everyone is served the winner
+		now := time.Now()
+		if e.EndsAt.IsZero() || e.EndsAt.After(now) {
+			e.EndsAt = now
+		}
+		if !e.StartsAt.IsZero() && !e.StartsAt.Before(e.EndsAt) {
+			e.StartsAt = time.Time{}
+		}
+
+		return nil
+	})
+}
+
+func runReset(args []string, stdin io.Reader, stdout io.Writer) error {
+	return updateExperiment(args, "reset", 0, stdout, func(e *swole.Experiment, args []string) error {
+		bumpVersion(e, swole.RestartExperiment)
+		return nil
+	})
+}
+
+func runResults(args []string, stdin io.Reader, stdout io.Writer) error {
+	f := newStoreFlags("results")
+	events := f.fs.String("events", "-", "file with the events written by a JSONLinesEventSink, - reads stdin")
+	sequential := f.fs.Bool("sequential", false, "compare the alternatives with the control using always valid p-values")
+	alpha := f.fs.Float64("alpha", 0.05, "significance level of the sequential test and of the values")
+	tau := f.fs.Float64("tau", 0.01, "expected difference of the conversion rates of the sequential test")
+	goal := f.fs.String("goal", "", "analyse the numeric values recorded for this goal instead of the conversions")
+	valueCap := f.fs.Float64("cap", 0, "cap the values of the goal above this value")
+	capPercentile := f.fs.Float64("cap-percentile", 0, "cap the values of the goal above this percentile, 0.99 for example")
+	values, err := f.parse(args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	store, release, err := f.experimentStore()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	experiment, err := getExperiment(store, values[0])
+	if err != nil {
+		return err
+	}
+
+	in := stdin
+	if *events != "-" {
+		fh, err := os.Open(*events)
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		in = fh
+	}
+
+	if len(*goal) > 0 {
+		params := swole.ValueParams{Alpha: *alpha, Cap: *valueCap, CapPercentile: *capPercentile}
+		results, err := swole.ValueResultsFromEvents(in, experiment, *goal, params)
+		if err != nil {
+			return err
+		}
+		return printValueResults(stdout, results, *f.json)
+	}
+
+	results, err := swole.ResultsFromEvents(in, experiment)
+	if err != nil {
+		return err
+	}
+
+	if *sequential {
+		return printSequentialTest(stdout, results, swole.SequentialParams{Alpha: *alpha, Tau: *tau}, *f.json)
+	}
+
+	if *f.json {
+		return writeJSON(stdout, results)
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ALTERNATIVE\tWEIGHT\tPARTICIPANTS\tCOMPLETIONS\tCONVERSION")
+	for _, a := range results.Alternatives {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.2f%%\n", a.Name, a.Weight, a.Participants, a.Completions, 100*a.ConversionRate())
+	}
+	fmt.Fprintf(tw, "total\t\t%d\t%d\t\n", results.Participants, results.Completions)
+	for _, f := range results.Factors {
+		fmt.Fprintln(tw)
+		fmt.Fprintf(tw, "FACTOR %s\tWEIGHT\tPARTICIPANTS\tCOMPLETIONS\tCONVERSION\n", f.Key)
+		for _, a := range f.Alternatives {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.2f%%\n", a.Name, a.Weight, a.Participants, a.Completions, 100*a.ConversionRate())
+		}
+	}
+	fmt.Fprintln(tw)
+	srm := "ok"
+	if results.SampleRatio.Mismatch {
+		srm = "MISMATCH, the participants are not split according to the weights"
+	}
+	fmt.Fprintf(tw, "sample ratio:\t%s (p = %.4g)\n", srm, results.SampleRatio.PValue)
+
+	return tw.Flush()
+}
+
+func printSequentialTest(w io.Writer, results *swole.ExperimentResults, params swole.SequentialParams, asJSON bool) error {
+	test, err := results.SequentialTest(params)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		return writeJSON(w, test)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ALTERNATIVE\tDIFFERENCE\tP-VALUE\tSIGNIFICANT")
+	for _, c := range test.Comparisons {
+		fmt.Fprintf(tw, "%s\t%+.2f%%\t%.4f\t%t\n", c.Name, 100*c.Difference, c.PValue, c.Significant)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintf(tw, "recommendation:\t%s\n", test.Recommendation)
+
+	return tw.Flush()
+}
+
+func printValueResults(w io.Writer, results *swole.ValueResults, asJSON bool) error {
+	if asJSON {
+		return writeJSON(w, results)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "goal:\t%s\n", results.Goal)
+	if results.Cap > 0 {
+		fmt.Fprintf(tw, "capped at:\t%g\n", results.Cap)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "ALTERNATIVE\tCOUNT\tMEAN\tSTDDEV\tCONFIDENCE\tDIFFERENCE\tP-VALUE")
+	for i, a := range results.Alternatives {
+		difference, pValue := "", ""
+		if i > 0 {
+			difference = fmt.Sprintf("%+.4g", a.Difference)
+			pValue = fmt.Sprintf("%.4f", a.PValue)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.4g\t%.4g\t[%.4g, %.4g]\t%s\t%s\n",
+			a.Name, a.Count, a.Mean, math.Sqrt(a.Variance), a.ConfidenceLow, a.ConfidenceHigh, difference, pValue)
+	}
+
+	return tw.Flush()
+}
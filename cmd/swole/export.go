@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/antonisgkamitsios/swole"
+)
+
+// splitList splits a comma separated flag value, an empty value is an empty list
+func splitList(value string) []string {
+	if len(value) == 0 {
+		return nil
+	}
+
+	return strings.Split(value, ",")
+}
+
+func parseTime(value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+func runExport(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	events := fs.String("events", "-", "file with the events written by a JSONLinesEventSink, - reads stdin")
+	output := fs.String("o", "-", "output file, - writes to stdout")
+	format := fs.String("format", "csv", "output format, csv or jsonl. Parquet is written by the swoleparquet module")
+	experiment := fs.String("experiment", "", "export only the events of this experiment")
+	alternatives := fs.String("alternatives", "", "comma separated alternatives to export")
+	types := fs.String("types", "", "comma separated event types to export: exposure, goal, value, sample_ratio_mismatch or failure")
+	from := fs.String("from", "", "export the events at or after this RFC 3339 time")
+	to := fs.String("to", "", "export the events before this RFC 3339 time")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	filter := swole.EventFilter{
+		Experiment:   *experiment,
+		Alternatives: splitList(*alternatives),
+	}
+	for _, t := range splitList(*types) {
+		filter.Types = append(filter.Types, swole.EventType(t))
+	}
+
+	var err error
+	filter.From, err = parseTime(*from)
+	if err != nil {
+		return fmt.Errorf("invalid -from: %w", err)
+	}
+	filter.To, err = parseTime(*to)
+	if err != nil {
+		return fmt.Errorf("invalid -to: %w", err)
+	}
+
+	in := stdin
+	if *events != "-" {
+		f, err := os.Open(*events)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	// the format is checked before the output is created, so that a typo does not truncate it
+	if _, err := swole.NewEventWriter(io.Discard, *format); err != nil {
+		return err
+	}
+
+	if *output == "-" {
+		w, err := swole.NewEventWriter(stdout, *format)
+		if err != nil {
+			return err
+		}
+		_, err = swole.ExportEvents(in, filter, w)
+		return err
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+
+	w, err := swole.NewEventWriter(f, *format)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	exported, err := swole.ExportEvents(in, filter, w)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "exported %d events to %s\n", exported, *output)
+	return nil
+}
@@ -0,0 +1,97 @@
+// Command swole works with the data of the swole library from the command line
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+)
+
+type command struct {
+	summary string
+	run     func(args []string, stdin io.Reader, stdout io.Writer) error
+}
+
+var commands = map[string]command{
+	"list": {
+		summary: "list the experiments of the store",
+		run:     runList,
+	},
+	"show": {
+		summary: "show an experiment",
+		run:     runShow,
+	},
+	"create": {
+		summary: "create an experiment",
+		run:     runCreate,
+	},
+	"set-weights": {
+		summary: "change the weights of the alternatives, or of the alternatives of every factor, existing participants keep theirs",
+		run:     runSetWeights,
+	},
+	"pause": {
+		summary: "stop enrolling new participants",
+		run:     runPause,
+	},
+	"resume": {
+		summary: "resume enrolling new participants",
+		run:     runResume,
+	},
+	"declare-winner": {
+		summary: "end the experiment and serve the winner to everyone",
+		run:     runDeclareWinner,
+	},
+	"reset": {
+		summary: "reassign every participant of the experiment",
+		run:     runReset,
+	},
+	"results": {
+		summary: "show the results of an experiment computed from its events",
+		run:     runResults,
+	},
+	"cookie": {
+		summary: "decode or encode the value of the swole cookie",
+		run:     runCookie,
+	},
+	"sample-size": {
+		summary: "compute the sample size and duration an experiment needs",
+		run:     runSampleSize,
+	},
+	"export": {
+		summary: "export the recorded events of an experiment as csv or jsonl",
+		run:     runExport,
+	},
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: swole <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, name := range slices.Sorted(maps.Keys(commands)) {
+		fmt.Fprintf(w, "  %-16s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "run `swole <command> -h` for the flags of a command")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	cmd, found := commands[os.Args[1]]
+	if !found {
+		fmt.Fprintf(os.Stderr, "swole: unknown command `%s`\n\n", os.Args[1])
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	err := cmd.run(os.Args[2:], os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "swole %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
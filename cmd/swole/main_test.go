@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// run runs a command the way main does and returns what it printed
+func run(t *testing.T, stdin string, args ...string) (string, error) {
+	t.Helper()
+
+	cmd, found := commands[args[0]]
+	if !found {
+		t.Fatalf("unknown command `%s`", args[0])
+	}
+
+	var stdout bytes.Buffer
+	err := cmd.run(args[1:], strings.NewReader(stdin), &stdout)
+
+	return stdout.String(), err
+}
+
+const events = `{"type":"exposure","time":"2026-03-01T09:00:00Z","experiment":"checkout","alternative":"control","subject":"user_1"}
+{"type":"exposure","time":"2026-03-01T10:00:00Z","experiment":"checkout","alternative":"variant","subject":"user_2"}
+{"type":"goal","time":"2026-03-01T11:00:00Z","experiment":"checkout","alternative":"variant","subject":"user_2"}
+{"type":"value","time":"2026-03-01T11:00:00Z","experiment":"checkout","alternative":"variant","subject":"user_2","goal":"order","value":42.5}
+{"type":"exposure","time":"2026-03-01T12:00:00Z","experiment":"other","alternative":"control","subject":"user_3"}
+`
+
+func TestExperimentCommands(t *testing.T) {
+	stores := []struct {
+		name string
+		env  map[string]string
+	}{
+		{name: "File", env: map[string]string{"SWOLE_STORE": filepath.Join(t.TempDir(), "experiments.json")}},
+		{name: "SQL", env: map[string]string{"SWOLE_DRIVER": "swoletest", "SWOLE_DSN": t.Name()}},
+	}
+
+	// the steps share the store, so they run in order
+	tests := []struct {
+		name    string
+		args    []string
+		stdin   string
+		wantErr bool
+		want    []string
+	}{
+		{
+			name: "Create",
+			args: []string{"create", "checkout", "-alternatives", "control,variant", "-weights", "1,3"},
+			want: []string{"key:      checkout", "status:   running", "variant      3"},
+		},
+		{
+			name:    "Create an existing experiment",
+			args:    []string{"create", "checkout", "-alternatives", "control,variant"},
+			wantErr: true,
+		},
+		{
+			name:    "Create an invalid experiment",
+			args:    []string{"create", "single", "-alternatives", "control"},
+			wantErr: true,
+		},
+		{
+			name:  "Create from JSON",
+			args:  []string{"create", "-f", "-"},
+			stdin: `{"key":"banner","alternatives":[{"name":"red"},{"name":"blue","weight":2,"payload":{"color":"blue"}}]}`,
+			want:  []string{"key:      banner", `blue         2       {"color":"blue"}`},
+		},
+		{
+			name:  "Create a multivariate experiment",
+			args:  []string{"create", "-f", "-"},
+			stdin: `{"key":"landing","factors":[{"key":"headline","alternatives":[{"name":"short"},{"name":"long"}]},{"key":"cta","alternatives":[{"name":"blue"},{"name":"red"}]}]}`,
+			want:  []string{"FACTOR headline", "FACTOR cta"},
+		},
+		{
+			name: "List",
+			args: []string{"list"},
+			want: []string{"banner    running  0        red,blue", "checkout  running  0        control,variant", "landing   running  0        short/blue,short/red,long/blue,long/red"},
+		},
+		{
+			name: "Set the weights of the factors",
+			args: []string{"set-weights", "landing", "1,3,2,1"},
+			want: []string{"version:  1", "long             3", "blue        2"},
+		},
+		{
+			name:    "Set the weights of the combinations",
+			args:    []string{"set-weights", "landing", "1,1,1,1,1"},
+			wantErr: true,
+		},
+		{
+			name: "Sample size of a multivariate experiment",
+			args: []string{"sample-size", "-experiment", "landing", "-baseline", "0.1", "-mde", "0.1"},
+			want: []string{"short/blue", "long/red"},
+		},
+		{
+			name: "Set weights bumps the version",
+			args: []string{"set-weights", "checkout", "1,1"},
+			want: []string{"version:  1", "variant      1"},
+		},
+		{
+			name:    "Set the wrong number of weights",
+			args:    []string{"set-weights", "checkout", "1,1,1"},
+			wantErr: true,
+		},
+		{
+			name: "Pause",
+			args: []string{"pause", "checkout"},
+			want: []string{"status:   paused"},
+		},
+		{
+			name: "Resume",
+			args: []string{"resume", "checkout"},
+			want: []string{"status:   running"},
+		},
+		{
+			name:    "Declare an unknown winner",
+			args:    []string{"declare-winner", "checkout", "other"},
+			wantErr: true,
+		},
+		{
+			name: "Declare the winner",
+			args: []string{"declare-winner", "checkout", "variant"},
+			want: []string{"status:   ended", "winner:   variant"},
+		},
+		{
+			name: "Reset restarts the experiment",
+			args: []string{"reset", "checkout", "-json"},
+			want: []string{`"version": 2`, `"2": "restart"`},
+		},
+		{
+			name:    "Show an unknown experiment",
+			args:    []string{"show", "missing"},
+			wantErr: true,
+		},
+		{
+			name:  "Results",
+			args:  []string{"results", "checkout"},
+			stdin: events,
+			want:  []string{"control      1       1             0            0.00%", "variant      1       1             1            100.00%", "sample ratio:"},
+		},
+		{
+			name:  "Results of a goal",
+			args:  []string{"results", "checkout", "-goal", "order", "-json"},
+			stdin: events,
+			want:  []string{`"goal": "order"`, `"mean": 42.5`},
+		},
+	}
+
+	for _, store := range stores {
+		t.Run(store.name, func(t *testing.T) {
+			for key, value := range store.env {
+				t.Setenv(key, value)
+			}
+
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					out, err := run(t, tt.stdin, tt.args...)
+					if tt.wantErr {
+						if err == nil {
+							t.Errorf("expected to error but got:\n%s", out)
+						}
+						return
+					}
+					if err != nil {
+						t.Fatalf("expected not to error but got: %v", err)
+					}
+
+					for _, want := range tt.want {
+						if !strings.Contains(out, want) {
+							t.Errorf("expected the output to contain %q but got:\n%s", want, out)
+						}
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestUnknownDriver(t *testing.T) {
+	t.Setenv("SWOLE_DRIVER", "missing")
+
+	_, err := run(t, "", "list")
+	if err == nil || !strings.Contains(err.Error(), "unknown driver `missing`") {
+		t.Errorf("expected an unknown driver error but got: %v", err)
+	}
+}
+
+func TestCookieCommand(t *testing.T) {
+	t.Setenv("SWOLE_SECRET", "")
+
+	value, err := run(t, "", "cookie", "encode", "-secret", "secret", "-version", "2", "checkout=variant")
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+		want    []string
+	}{
+		{
+			name: "Verified signature",
+			args: []string{"cookie", "decode", "-secret", "secret", "swole=" + strings.TrimSpace(value)},
+			want: []string{"signature:  verified", "checkout    variant      2        false"},
+		},
+		{
+			name: "Signature without a secret",
+			args: []string{"cookie", "decode", strings.TrimSpace(value)},
+			want: []string{"not verified"},
+		},
+		{
+			name:    "Wrong secret",
+			args:    []string{"cookie", "decode", "-secret", "other", strings.TrimSpace(value)},
+			wantErr: true,
+		},
+		{
+			name:    "Malformed assignment",
+			args:    []string{"cookie", "encode", "checkout"},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown action",
+			args:    []string{"cookie", "sign"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := run(t, "", tt.args...)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected to error but got:\n%s", out)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("expected the output to contain %q but got:\n%s", want, out)
+				}
+			}
+		})
+	}
+}
+
+func TestSampleSizeCommand(t *testing.T) {
+	t.Setenv("SWOLE_STORE", filepath.Join(t.TempDir(), "experiments.json"))
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+		want    []string
+	}{
+		{
+			name: "Equal weights",
+			args: []string{"sample-size", "-baseline", "0.1", "-mde", "0.1", "-daily-traffic", "1000"},
+			want: []string{"total                  29498", "duration:  30 days"},
+		},
+		{
+			name:    "Invalid baseline",
+			args:    []string{"sample-size", "-baseline", "2", "-mde", "0.1"},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown experiment",
+			args:    []string{"sample-size", "-baseline", "0.1", "-mde", "0.1", "-experiment", "missing"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := run(t, "", tt.args...)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected to error but got:\n%s", out)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("expected the output to contain %q but got:\n%s", want, out)
+				}
+			}
+		})
+	}
+}
+
+func TestExportCommand(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "events.csv")
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+		want    string
+	}{
+		{
+			name: "Filtered csv",
+			args: []string{"export", "-experiment", "checkout", "-types", "exposure", "-from", "2026-03-01T10:00:00Z"},
+			want: "type,time,experiment,alternative,subject,goal,value,failure,holdout\nexposure,2026-03-01T10:00:00Z,checkout,variant,user_2,,,,\n",
+		},
+		{
+			name: "Values as jsonl",
+			args: []string{"export", "-format", "jsonl", "-types", "value"},
+			want: `{"type":"value","time":"2026-03-01T11:00:00Z","experiment":"checkout","alternative":"variant","subject":"user_2","goal":"order","value":42.5}` + "\n",
+		},
+		{
+			name: "To a file",
+			args: []string{"export", "-alternatives", "control", "-o", output},
+			want: "exported 2 events to " + output + "\n",
+		},
+		{
+			name:    "Unsupported format",
+			args:    []string{"export", "-format", "parquet"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid time",
+			args:    []string{"export", "-from", "yesterday"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := run(t, events, tt.args...)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected to error but got:\n%s", out)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+
+			if out != tt.want {
+				t.Errorf("expected the output to be:\n%s\nbut got:\n%s", tt.want, out)
+			}
+		})
+	}
+	t.Run("Unsupported format keeps the output", func(t *testing.T) {
+		before, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+
+		_, err = run(t, events, "export", "-format", "parquet", "-o", output)
+		if err == nil {
+			t.Fatal("expected to error but did not")
+		}
+
+		after, _ := os.ReadFile(output)
+		if !bytes.Equal(before, after) {
+			t.Errorf("expected the output to be kept but got:\n%s", after)
+		}
+	})
+}
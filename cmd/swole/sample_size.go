@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/antonisgkamitsios/swole"
+)
+
+func runSampleSize(args []string, stdin io.Reader, stdout io.Writer) error {
+	f := newStoreFlags("sample-size")
+	baseline := f.fs.Float64("baseline", 0, "conversion rate of the control, between 0 and 1")
+	mde := f.fs.Float64("mde", 0, "minimum detectable effect relative to the baseline, 0.1 is a 10% lift")
+	alpha := f.fs.Float64("alpha", 0.05, "significance level")
+	power := f.fs.Float64("power", 0.8, "statistical power")
+	weights := f.fs.String("weights", "1,1", "comma separated weights of the alternatives, the first one is the control")
+	experiment := f.fs.String("experiment", "", "use the alternatives of this experiment of the store instead of -weights")
+	allocation := f.fs.Float64("allocation", 1, "share of the traffic that enters the experiment")
+	dailyTraffic := f.fs.Int("daily-traffic", 0, "visitors per day, used to estimate the duration")
+	_, err := f.parse(args, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	params := swole.SampleSizeParams{
+		BaselineRate:            *baseline,
+		MinimumDetectableEffect: *mde,
+		Alpha:                   *alpha,
+		Power:                   *power,
+		TrafficAllocation:       *allocation,
+		DailyTraffic:            *dailyTraffic,
+	}
+
+	if len(*experiment) > 0 {
+		store, release, err := f.experimentStore()
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		e, err := getExperiment(store, *experiment)
+		if err != nil {
+			return err
+		}
+		params.Alternatives = e.ServedAlternatives()
+	} else {
+		for i, value := range splitList(*weights) {
+			weight, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid weight `%s`", value)
+			}
+			name := "control"
+			if i > 0 {
+				name = fmt.Sprintf("alternative_%d", i)
+			}
+			params.Alternatives = append(params.Alternatives, swole.Alternative{Name: name, Weight: weight})
+		}
+	}
+
+	estimate, err := swole.SampleSize(params)
+	if err != nil {
+		return err
+	}
+
+	if *f.json {
+		return writeJSON(stdout, estimate)
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ALTERNATIVE\tWEIGHT\tSAMPLE SIZE")
+	for _, a := range estimate.Alternatives {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", a.Name, a.Weight, a.SampleSize)
+	}
+	fmt.Fprintf(tw, "total\t\t%d\n", estimate.SampleSize)
+	if estimate.Days > 0 {
+		fmt.Fprintln(tw)
+		fmt.Fprintf(tw, "duration:\t%d days\n", estimate.Days)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,135 @@
+package swole
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidSignature = errors.New("invalid cookie signature")
+
+// CookieAssignment is an assignment stored in the swole cookie
+type CookieAssignment struct {
+	Alternative string `json:"alternative"`
+	Version     int    `json:"version"`
+	Finished    bool   `json:"finished,omitempty"`
+}
+
+// CookieAssignments is the decoded value of the swole cookie keyed by experiment
+type CookieAssignments map[string]CookieAssignment
+
+// DecodeCookieValue decodes the value of the swole cookie as it is sent by the browser or
+// as it is stored once unescaped. When secret is set the value must carry a valid signature,
+// otherwise signed values are decoded without being verified. signed reports whether the
+// value carried a signature
+func DecodeCookieValue(value string, secret []byte) (assignments CookieAssignments, signed bool, err error) {
+	unescaped, err := url.QueryUnescape(value)
+	if err != nil {
+		return nil, false, err
+	}
+
+	_, _, signed = splitSignature(unescaped)
+	fields, err := decodeCookieFields(unescaped, secret)
+	if err != nil {
+		return nil, signed, err
+	}
+
+	assignments = make(CookieAssignments)
+	for key, alternative := range fields {
+		if strings.HasSuffix(key, ":version") || strings.HasSuffix(key, ":finished") {
+			continue
+		}
+
+		assignment := CookieAssignment{
+			Alternative: alternative,
+			Finished:    len(fields[key+":finished"]) > 0,
+		}
+		if rawVersion, found := fields[key+":version"]; found {
+			assignment.Version, err = strconv.Atoi(rawVersion)
+			if err != nil {
+				return nil, signed, err
+			}
+		}
+		assignments[key] = assignment
+	}
+
+	return assignments, signed, nil
+}
+
+// EncodeCookieValue encodes the assignments into a value of the swole cookie, escaped the
+// same way it is sent to the browser. The value is signed when secret is set
+func EncodeCookieValue(assignments CookieAssignments, secret []byte) (string, error) {
+	fields := make(map[string]string, 2*len(assignments))
+	for key, assignment := range assignments {
+		fields[key] = assignment.Alternative
+		fields[key+":version"] = strconv.Itoa(assignment.Version)
+		if assignment.Finished {
+			fields[key+":finished"] = "true"
+		}
+	}
+
+	value, err := encodeCookieFields(fields, secret)
+	if err != nil {
+		return "", err
+	}
+
+	return url.QueryEscape(value), nil
+}
+
+// decodeCookieFields decodes an unescaped value of the swole cookie into its raw fields
+func decodeCookieFields(value string, secret []byte) (map[string]string, error) {
+	payload, signature, signed := splitSignature(value)
+	if len(secret) > 0 && (!signed || !hmac.Equal(signature, sign(payload, secret))) {
+		return nil, ErrInvalidSignature
+	}
+
+	var fields map[string]string
+	err := json.Unmarshal([]byte(payload), &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// encodeCookieFields encodes the raw fields of the swole cookie, the result is not escaped
+func encodeCookieFields(fields map[string]string, secret []byte) (string, error) {
+	payload, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+
+	if len(secret) == 0 {
+		return string(payload), nil
+	}
+
+	return string(payload) + "." + base64.RawURLEncoding.EncodeToString(sign(string(payload), secret)), nil
+}
+
+// splitSignature splits a signed value into its JSON payload and its signature. The signature
+// comes after the last dot, which never appears in the base64 encoding of the signature
+func splitSignature(value string) (payload string, signature []byte, signed bool) {
+	i := strings.LastIndexByte(value, '.')
+	if i < 0 || strings.HasSuffix(value, "}") {
+		return value, nil, false
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(value[i+1:])
+	if err != nil {
+		return value, nil, false
+	}
+
+	return value[:i], signature, true
+}
+
+func sign(payload string, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+
+	return mac.Sum(nil)
+}
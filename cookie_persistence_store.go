@@ -1,15 +1,31 @@
 package swole
 
 import (
-	"encoding/json"
+	"crypto/hmac"
+	"encoding/base64"
 	"errors"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 )
 
-const cookieName = "swole"
+const (
+	cookieName         = "swole"
+	identityCookieName = "swole_id"
+)
 
 type CookiePersistenceStore struct {
 	MaxAge int
+	// Secret signs the cookies so that participants cannot choose their own alternatives, nor
+	// their identity which decides their layer and holdout buckets. Once it is set cookies
+	// without a valid signature are rejected, see FailurePolicy, and identities without one
+	// are replaced
+	Secret []byte
+	// AcceptUnsigned accepts the cookies written before the Secret was set, they are signed the
+	// next time they are written. It is meant for the migration window, cookies with an invalid
+	// signature are still rejected
+	AcceptUnsigned bool
 }
 
 func NewCookiePersistenceStore() *CookiePersistenceStore {
@@ -31,41 +47,180 @@ func (s *CookiePersistenceStore) generateCookie(value string) http.Cookie {
 	}
 }
 
-func (s *CookiePersistenceStore) writeCookie(w http.ResponseWriter, value string) error {
+func (s *CookiePersistenceStore) writeCookie(w http.ResponseWriter, fields map[string]string) error {
+	value, err := encodeCookieFields(fields, s.Secret)
+	if err != nil {
+		return err
+	}
 	cookie := s.generateCookie(value)
 
 	return writeCookie(w, cookie)
 }
 
-func (s *CookiePersistenceStore) ExperimentExists(key string, w http.ResponseWriter, r *http.Request) (bool, string, error) {
-	cookie, err := readCookie(r, cookieName)
+// readCookie returns the cookie holding the assignments, a cookie that was already written while
+// handling the same request is newer than the one sent with the request
+func (s *CookiePersistenceStore) readCookie(w http.ResponseWriter, r *http.Request) (*http.Cookie, error) {
+	cookie := readResponseCookie(w, cookieName)
+	if cookie == nil {
+		return readCookie(r, cookieName)
+	}
+
+	unescapedValue, err := url.QueryUnescape(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+	cookie.Value = unescapedValue
+
+	return cookie, nil
+}
+
+// decodeFields decodes the value of the cookie, unsigned values are accepted during the migration window
+func (s *CookiePersistenceStore) decodeFields(value string) (map[string]string, error) {
+	fields, err := decodeCookieFields(value, s.Secret)
+	if errors.Is(err, ErrInvalidSignature) && s.AcceptUnsigned {
+		if _, _, signed := splitSignature(value); !signed {
+			return decodeCookieFields(value, nil)
+		}
+	}
+
+	return fields, err
+}
+
+func (s *CookiePersistenceStore) Reset(w http.ResponseWriter, r *http.Request) error {
+	return s.writeCookie(w, map[string]string{})
+}
+
+// KnownIdentity returns the identity of the request without creating one, identities without
+// a valid signature are not known
+func (s *CookiePersistenceStore) KnownIdentity(r *http.Request) string {
+	cookie, err := r.Cookie(identityCookieName)
+	if err != nil {
+		return ""
+	}
+	id, _ := s.verifyIdentity(cookie.Value)
+
+	return id
+}
+
+// verifyIdentity returns the identity held by the value of the identity cookie, and whether it
+// must be written again because it was accepted unsigned
+func (s *CookiePersistenceStore) verifyIdentity(value string) (id string, resign bool) {
+	if len(s.Secret) == 0 {
+		return value, false
+	}
+
+	id, signature, found := splitIdentity(value)
+	if found && hmac.Equal(signature, sign(id, s.Secret)) {
+		return id, false
+	}
+	if !found && s.AcceptUnsigned {
+		return value, true
+	}
+
+	return "", false
+}
+
+// splitIdentity splits a signed identity into the identity and its signature, identities are
+// hex encoded so they never contain a dot
+func splitIdentity(value string) (id string, signature []byte, signed bool) {
+	id, encoded, found := strings.Cut(value, ".")
+	if !found {
+		return value, nil, false
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return value, nil, false
+	}
+
+	return id, signature, true
+}
+
+func (s *CookiePersistenceStore) Identity(w http.ResponseWriter, r *http.Request) (string, error) {
+	// the identity might have been created earlier while handling the same request
+	if cookie := readResponseCookie(w, identityCookieName); cookie != nil {
+		id, _ := s.verifyIdentity(cookie.Value)
+		return id, nil
+	}
+
+	cookie, err := r.Cookie(identityCookieName)
+	if err != nil && !errors.Is(err, http.ErrNoCookie) {
+		return "", err
+	}
+
+	var id string
+	resign := false
+	if err == nil {
+		id, resign = s.verifyIdentity(cookie.Value)
+	}
+	if len(id) > 0 && !resign {
+		return id, nil
+	}
+
+	// identities that cannot be verified are replaced, so that participants cannot choose their buckets
+	if len(id) == 0 {
+		id, err = randomID()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	value := id
+	if len(s.Secret) > 0 {
+		value += "." + base64.RawURLEncoding.EncodeToString(sign(id, s.Secret))
+	}
+
+	cookie = &http.Cookie{
+		Name:     identityCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   60 * 60 * 24 * 365, // one year, the identity must outlive the experiments
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(w, cookie)
+
+	return id, nil
+}
+
+func (s *CookiePersistenceStore) ExperimentExists(key string, w http.ResponseWriter, r *http.Request) (bool, string, int, error) {
+	cookie, err := s.readCookie(w, r)
 	// we didn't find cookie therefore experiment does not exist
 	if errors.Is(err, http.ErrNoCookie) {
-		return false, "", nil
+		return false, "", 0, nil
 	}
 	if err != nil {
-		return false, "", err
+		return false, "", 0, err
 	}
 
 	// we need to check cookie to see if our experiment is in there
-	// {"experiment_name": "control", "experiment_name:finished": "true"}
-	var parsedCookieValue map[string]string
-	err = json.Unmarshal([]byte(cookie.Value), &parsedCookieValue)
+	// {"experiment_name": "control", "experiment_name:version": "1", "experiment_name:finished": "true"}
+	parsedCookieValue, err := s.decodeFields(cookie.Value)
 	if err != nil {
-		return false, "", err
+		return false, "", 0, err
 	}
 
 	alternative, found := parsedCookieValue[key]
-	if found {
-		return true, alternative, nil
+	if !found {
+		return false, "", 0, nil
 	}
 
-	return false, "", nil
+	// cookies written before versioning was introduced have no version, which means version 0
+	version := 0
+	if rawVersion, found := parsedCookieValue[key+":version"]; found {
+		version, err = strconv.Atoi(rawVersion)
+		if err != nil {
+			return false, "", 0, err
+		}
+	}
+
+	return true, alternative, version, nil
 }
 
-func (s *CookiePersistenceStore) PersistExperiment(key, alternative string, w http.ResponseWriter, r *http.Request) (err error) {
+func (s *CookiePersistenceStore) PersistExperiment(key, alternative string, version int, w http.ResponseWriter, r *http.Request) (err error) {
 	cookieExists := true
-	cookie, err := readCookie(r, cookieName)
+	cookie, err := s.readCookie(w, r)
 	// we didn't find cookie therefore experiment does not exist
 	if errors.Is(err, http.ErrNoCookie) {
 		cookieExists = false
@@ -76,47 +231,42 @@ func (s *CookiePersistenceStore) PersistExperiment(key, alternative string, w ht
 	parsedCookieValue := make(map[string]string)
 
 	if cookieExists {
-		err = json.Unmarshal([]byte(cookie.Value), &parsedCookieValue)
+		parsedCookieValue, err = s.decodeFields(cookie.Value)
 		if err != nil {
 			return err
 		}
 	}
 
 	parsedCookieValue[key] = alternative
-	newValue, err := json.Marshal(&parsedCookieValue)
-	if err != nil {
-		return err
-	}
-	err = s.writeCookie(w, string(newValue))
-	if err != nil {
-		return nil
-	}
+	parsedCookieValue[key+":version"] = strconv.Itoa(version)
+	// this is a fresh assignment so it cannot be finished yet
+	delete(parsedCookieValue, key+":finished")
 
-	return nil
+	return s.writeCookie(w, parsedCookieValue)
 }
 
 func (s *CookiePersistenceStore) RefreshTtl(w http.ResponseWriter, r *http.Request) error {
 
-	cookie, err := readCookie(r, cookieName)
+	cookie, err := s.readCookie(w, r)
 	if err != nil {
 		return err
 	}
 
-	err = s.writeCookie(w, cookie.Value)
+	parsedCookieValue, err := s.decodeFields(cookie.Value)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	return s.writeCookie(w, parsedCookieValue)
 }
 
 func (s *CookiePersistenceStore) ExperimentFinish(key string, w http.ResponseWriter, r *http.Request) (finishFirstTime bool, err error) {
-	cookie, err := readCookie(r, cookieName)
+	cookie, err := s.readCookie(w, r)
 	if err != nil {
 		return false, err
 	}
 
-	var parsedCookieValue map[string]string
-	err = json.Unmarshal([]byte(cookie.Value), &parsedCookieValue)
+	parsedCookieValue, err := s.decodeFields(cookie.Value)
 	if err != nil {
 		return false, err
 	}
@@ -126,12 +276,7 @@ func (s *CookiePersistenceStore) ExperimentFinish(key string, w http.ResponseWri
 
 	parsedCookieValue[finishedKey] = "true"
 
-	newValue, err := json.Marshal(&parsedCookieValue)
-	if err != nil {
-		return false, err
-	}
-
-	err = s.writeCookie(w, string(newValue))
+	err = s.writeCookie(w, parsedCookieValue)
 	if err != nil {
 		return false, err
 	}
@@ -19,3 +19,39 @@ type ExperimentNotFoundError struct {
 func (e *ExperimentNotFoundError) Error() string {
 	return fmt.Sprintf("cannot retrieve experiment with key: `%s`: %s", e.key, e.message)
 }
+
+type InvalidLayerError struct {
+	message string
+	key     string
+}
+
+func (e *InvalidLayerError) Error() string {
+	return fmt.Sprintf("cannot register layer with key: `%s`: %s", e.key, e.message)
+}
+
+type InvalidHoldoutError struct {
+	message string
+	key     string
+}
+
+func (e *InvalidHoldoutError) Error() string {
+	return fmt.Sprintf("cannot register holdout with key: `%s`: %s", e.key, e.message)
+}
+
+type InvalidFlagError struct {
+	message string
+	key     string
+}
+
+func (e *InvalidFlagError) Error() string {
+	return fmt.Sprintf("cannot register flag with key: `%s`: %s", e.key, e.message)
+}
+
+type FlagNotFoundError struct {
+	message string
+	key     string
+}
+
+func (e *FlagNotFoundError) Error() string {
+	return fmt.Sprintf("cannot retrieve flag with key: `%s`: %s", e.key, e.message)
+}
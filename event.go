@@ -0,0 +1,170 @@
+package swole
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	// EventExposure is emitted when a participant is enrolled in an experiment
+	EventExposure EventType = "exposure"
+	// EventGoal is emitted when a participant finishes an experiment for the first time
+	EventGoal EventType = "goal"
+	// EventValue is emitted every time a numeric value is recorded for a goal
+	EventValue EventType = "value"
+	// EventSampleRatioMismatch is emitted when the participants of an experiment are not split
+	// according to the weights, the p-value of the check is the Value
+	EventSampleRatioMismatch EventType = "sample_ratio_mismatch"
+	// EventFailure is emitted when the persistence failed and the FailurePolicy decided what was
+	// served, the policy that was applied is the Failure
+	EventFailure EventType = "failure"
+)
+
+// Event is a raw record of what happened to a participant, it is meant for offline analysis
+type Event struct {
+	Type        EventType `json:"type"`
+	Time        time.Time `json:"time"`
+	Experiment  string    `json:"experiment"`
+	Alternative string    `json:"alternative"`
+	// Subject is the identity of the participant, when it is known
+	Subject string `json:"subject,omitempty"`
+	// Goal and Value are set on EventValue, Value is also set on EventSampleRatioMismatch
+	Goal  string  `json:"goal,omitempty"`
+	Value float64 `json:"value,omitempty"`
+	// Failure is set on EventFailure
+	Failure FailurePolicy `json:"failure,omitempty"`
+	// Holdout is set on the events of held out participants, who were served the control
+	// without being enrolled in the experiment
+	Holdout string `json:"holdout,omitempty"`
+}
+
+// EventSink receives the events of the ExperimentManager. Emitting is best effort,
+// errors are logged but they never fail the experiment operation
+type EventSink interface {
+	Emit(ctx context.Context, event Event) error
+}
+
+// JSONLinesEventSink writes every event as a line of JSON, the output can be exported with ExportEvents
+type JSONLinesEventSink struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+func NewJSONLinesEventSink(w io.Writer) *JSONLinesEventSink {
+	return &JSONLinesEventSink{
+		encoder: json.NewEncoder(w),
+	}
+}
+
+func (s *JSONLinesEventSink) Emit(ctx context.Context, event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.encoder.Encode(event)
+}
+
+func (m *ExperimentManager) emit(ctx context.Context, event Event) {
+	if m.EventSink == nil {
+		return
+	}
+
+	err := m.EventSink.Emit(ctx, event)
+	if err != nil && m.Logger != nil {
+		m.Logger.LogAttrs(ctx, slog.LevelError, "swole: cannot emit event",
+			slog.String(AttributeExperiment, event.Experiment),
+			slog.String("swole.event", string(event.Type)),
+			slog.Any("error", err),
+		)
+	}
+}
+
+// emitFailure records the decision of the FailurePolicy along with the alternative that was served
+func (m *ExperimentManager) emitFailure(ctx context.Context, key, alternative string, p participant, failure FailurePolicy) {
+	m.emit(ctx, Event{
+		Type:        EventFailure,
+		Time:        m.Now(),
+		Experiment:  key,
+		Alternative: alternative,
+		Subject:     p.knownIdentity(),
+		Failure:     failure,
+	})
+}
+
+// emitHoldout emits an event of a held out participant, the value is only set on EventValue
+func (m *ExperimentManager) emitHoldout(ctx context.Context, eventType EventType, key string, holdout Holdout, alternative string, p participant, value *goalValue) {
+	event := Event{
+		Type:        eventType,
+		Time:        m.Now(),
+		Experiment:  key,
+		Alternative: alternative,
+		Subject:     p.knownIdentity(),
+		Holdout:     holdout.Key,
+	}
+	if value != nil {
+		event.Goal, event.Value = value.goal, value.value
+	}
+
+	m.emit(ctx, event)
+}
+
+func (m *ExperimentManager) emitStart(ctx context.Context, key string, p participant, response *StartExperimentResponse, err error) {
+	if err != nil {
+		return
+	}
+	if len(response.Failure) > 0 {
+		m.emitFailure(ctx, key, response.Alternative, p, response.Failure)
+	}
+	if !response.DidStartFirstTime {
+		return
+	}
+
+	m.emit(ctx, Event{
+		Type:        EventExposure,
+		Time:        m.Now(),
+		Experiment:  key,
+		Alternative: response.Alternative,
+		Subject:     p.knownIdentity(),
+	})
+}
+
+// emitFinish emits the events of a finish, the alternative of the response is the one the
+// participant was enrolled in so the events match what is tracked
+func (m *ExperimentManager) emitFinish(ctx context.Context, key string, p participant, value *goalValue, response *FinishExperimentResponse, err error) {
+	if err != nil {
+		return
+	}
+	if len(response.Failure) > 0 {
+		m.emitFailure(ctx, key, response.Alternative, p, response.Failure)
+	}
+	if !response.DidFinish {
+		return
+	}
+
+	if response.DidFinishFirstTime {
+		m.emit(ctx, Event{
+			Type:        EventGoal,
+			Time:        m.Now(),
+			Experiment:  key,
+			Alternative: response.Alternative,
+			Subject:     p.knownIdentity(),
+		})
+	}
+
+	if value != nil && len(response.Failure) == 0 {
+		m.emit(ctx, Event{
+			Type:        EventValue,
+			Time:        m.Now(),
+			Experiment:  key,
+			Alternative: response.Alternative,
+			Subject:     p.knownIdentity(),
+			Goal:        value.goal,
+			Value:       value.value,
+		})
+	}
+}
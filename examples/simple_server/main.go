@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
 
 	"github.com/antonisgkamitsios/swole"
 )
@@ -10,7 +12,12 @@ import (
 func main() {
 	mux := http.NewServeMux()
 
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
 	manager := swole.NewExperimentManager()
+	manager.Logger = logger
+	// participants with a corrupted cookie are assigned again instead of getting an error
+	manager.FailurePolicy = swole.ResetAndReassign
 	manager.RegisterExperiment(swole.Experiment{
 		Key: "test_experiment",
 		Alternatives: swole.Alternatives{
@@ -22,7 +29,6 @@ func main() {
 	mux.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {
 		res, err := manager.StartExperiment("test_experiment", w, r)
 		if err != nil {
-			fmt.Printf("startExeperiment %v", err)
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
@@ -32,7 +38,6 @@ func main() {
 	mux.HandleFunc("GET /finish", func(w http.ResponseWriter, r *http.Request) {
 		res, err := manager.FinishExperiment("test_experiment", w, r)
 		if err != nil {
-			fmt.Printf("startExeperiment %v", err)
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
@@ -40,6 +45,12 @@ func main() {
 		fmt.Fprintf(w, `The experiment finish response is: %+v`, res)
 	})
 
-	fmt.Println("Server is running on port :3000")
-	http.ListenAndServe(":3000", mux)
+	mux.Handle("GET /metrics", manager.PrometheusHandler())
+
+	logger.Info("server is running", slog.String("addr", ":3000"))
+	err := http.ListenAndServe(":3000", mux)
+	if err != nil {
+		logger.Error("server stopped", slog.Any("error", err))
+		os.Exit(1)
+	}
 }
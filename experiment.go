@@ -2,7 +2,10 @@ package swole
 
 import (
 	"encoding/json"
+	"fmt"
 	"math/rand"
+	"slices"
+	"time"
 )
 
 type Alternatives []Alternative
@@ -19,39 +22,392 @@ func (a Alternatives) getNames() []string {
 }
 
 type Alternative struct {
-	Weight int
-	Name   string
+	Weight int    `json:"weight,omitempty"`
+	Name   string `json:"name"`
+	// Payload is the configuration served along with the alternative, it must be valid JSON
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// VersionPolicy describes what happens to participants that were assigned under
+// an older version of an experiment
+type VersionPolicy string
+
+const (
+	// KeepAssignments keeps every existing assignment. Participants whose alternative was
+	// removed are no longer enrolled, they are served the first alternative and their
+	// completions are not recorded, see ExcludedAlternativeRemoved
+	KeepAssignments VersionPolicy = "keep"
+	// ReassignRemoved keeps existing assignments but assigns the participants whose
+	// alternative no longer exists again, so they stay enrolled
+	ReassignRemoved VersionPolicy = "reassign_removed"
+	// RestartExperiment reassigns every participant that was assigned under an older version
+	RestartExperiment VersionPolicy = "restart"
+)
+
+// strength is used to pick the most disruptive policy when a participant skipped
+// several versions
+func (p VersionPolicy) strength() int {
+	switch p {
+	case ReassignRemoved:
+		return 1
+	case RestartExperiment:
+		return 2
+	default:
+		return 0
+	}
+}
+
+func (p VersionPolicy) valid() bool {
+	switch p {
+	case "", KeepAssignments, ReassignRemoved, RestartExperiment:
+		return true
+	default:
+		return false
+	}
 }
 
 type Experiment struct {
-	Key          string
-	Alternatives Alternatives
+	Key          string       `json:"key"`
+	Alternatives Alternatives `json:"alternatives"`
+	// Version should be bumped every time the weights or the alternatives change
+	Version int `json:"version,omitempty"`
+	// VersionPolicies holds the policy of each version bump, keyed by the version that
+	// introduced the change. Versions without a policy default to KeepAssignments
+	VersionPolicies map[int]VersionPolicy `json:"version_policies,omitempty"`
+	// Layer is the key of the layer the experiment belongs to, if any
+	Layer string `json:"layer,omitempty"`
+	// Buckets is the range of the layer buckets owned by the experiment
+	Buckets BucketRange `json:"buckets,omitzero"`
+	// StartsAt is the time the experiment starts enrolling participants, zero means right away.
+	// The time carries its own location so windows can be defined in any timezone
+	StartsAt time.Time `json:"starts_at,omitzero"`
+	// EndsAt is the time the experiment stops enrolling participants, zero means never. From
+	// then on everyone is served the Winner and completions are no longer recorded
+	EndsAt time.Time `json:"ends_at,omitzero"`
+	// Winner is the alternative served to everyone once the experiment has ended,
+	// when empty the first alternative is served
+	Winner string `json:"winner,omitempty"`
+	// MaxParticipants caps the number of participants, zero means no cap. Once the cap is
+	// reached new participants are served the first alternative without being enrolled. The
+	// cap holds across instances of the application that share a SQLTrackingStore
+	MaxParticipants int `json:"max_participants,omitempty"`
+	// Paused stops the experiment from enrolling participants, the ones already
+	// enrolled keep being served their alternative
+	Paused bool `json:"paused,omitempty"`
+	// Allocator chooses the alternatives of new participants instead of the Allocator of the
+	// manager, for example a multi-armed bandit. The split of the participants is only checked
+	// when the allocator in effect is a WeightedAllocator. It is not stored, experiments of the
+	// ExperimentStore keep the Allocator of the registered experiment they override
+	Allocator Allocator `json:"-"`
+	// Factors turn the experiment into a multivariate one, participants are assigned a
+	// combination of the alternatives of every factor. The alternatives of the experiment
+	// are generated from the factors and named after their alternatives, see
+	// Experiment.CombinationName. Renaming an alternative or reordering the factors renames
+	// the combinations, so it needs a new Version
+	Factors []Factor `json:"factors,omitempty"`
+}
+
+// Validate checks that the experiment is well formed. Layers are checked when the
+// experiment is registered since they depend on the other experiments
+func (e Experiment) Validate() error {
+	key := e.Key
+
+	if len(key) == 0 {
+		return &InvalidExperimentError{
+			message: "the key cannot be empty",
+			key:     key,
+		}
+	}
+
+	if len(e.Factors) > 0 {
+		err := e.validateFactors()
+		if err != nil {
+			return err
+		}
+	}
+	e = e.served()
+
+	if len(e.Alternatives) < 2 {
+		return &InvalidExperimentError{
+			message: "should have at least 2 alternatives",
+			key:     key,
+		}
+	}
+
+	if !unique(e.Alternatives.getNames()) {
+		return &InvalidExperimentError{
+			message: "alternatives must be unique",
+			key:     key,
+		}
+	}
+
+	if e.MaxParticipants < 0 {
+		return &InvalidExperimentError{
+			message: "max participants must be positive",
+			key:     key,
+		}
+	}
+
+	if e.Version < 0 {
+		return &InvalidExperimentError{
+			message: "version must be positive",
+			key:     key,
+		}
+	}
+
+	for version, policy := range e.VersionPolicies {
+		if version < 1 || version > e.Version {
+			return &InvalidExperimentError{
+				message: fmt.Sprintf("version policy defined for unknown version %d", version),
+				key:     key,
+			}
+		}
+		if !policy.valid() {
+			return &InvalidExperimentError{
+				message: fmt.Sprintf("unknown version policy `%s`", policy),
+				key:     key,
+			}
+		}
+	}
+
+	if !e.StartsAt.IsZero() && !e.EndsAt.IsZero() && !e.EndsAt.After(e.StartsAt) {
+		return &InvalidExperimentError{
+			message: "end time must be after the start time",
+			key:     key,
+		}
+	}
+
+	if len(e.Winner) > 0 && !e.hasAlternative(e.Winner) {
+		return &InvalidExperimentError{
+			message: fmt.Sprintf("winner `%s` is not one of the alternatives", e.Winner),
+			key:     key,
+		}
+	}
+
+	for _, alt := range e.Alternatives {
+		if alt.Payload != nil && !json.Valid(alt.Payload) {
+			return &InvalidExperimentError{
+				message: fmt.Sprintf("payload of alternative `%s` is not valid JSON", alt.Name),
+				key:     key,
+			}
+		}
+
+		if alt.Weight < 0 {
+			return &InvalidExperimentError{
+				message: "weights must be positive",
+				key:     key,
+			}
+		}
+	}
+
+	return nil
 }
 
+// ExclusionReason explains why a participant was not enrolled in an experiment
+type ExclusionReason string
+
+const (
+	// ExcludedByLayer means the participant falls in a bucket of the layer that
+	// is not owned by the experiment
+	ExcludedByLayer ExclusionReason = "layer"
+	// ExcludedNotStarted means the experiment has not started yet
+	ExcludedNotStarted ExclusionReason = "not_started"
+	// ExcludedEnded means the experiment has ended
+	ExcludedEnded ExclusionReason = "ended"
+	// ExcludedCapReached means the experiment already has the maximum number of participants
+	ExcludedCapReached ExclusionReason = "cap_reached"
+	// ExcludedBot means the request was made by a bot
+	ExcludedBot ExclusionReason = "bot"
+	// ExcludedPaused means the experiment is paused
+	ExcludedPaused ExclusionReason = "paused"
+	// ExcludedHoldout means the participant belongs to a holdout
+	ExcludedHoldout ExclusionReason = "holdout"
+	// ExcludedAlternativeRemoved means the alternative of the participant was removed and the
+	// policy of the experiment keeps the assignments instead of assigning them again
+	ExcludedAlternativeRemoved ExclusionReason = "alternative_removed"
+)
+
 type StartExperimentResponse struct {
 	DidStart          bool
 	DidStartFirstTime bool
 	Alternative       string
+	// Payload is the payload of the served alternative
+	Payload json.RawMessage
+	// Excluded is set when the participant was not enrolled in the experiment
+	Excluded ExclusionReason
+	// Propagated is set when the alternative was assigned by an upstream service
+	Propagated bool
+	// Reassigned is set when an assignment made under an older version of the experiment was replaced
+	Reassigned bool
+	// Combination is the alternative of every factor of a multivariate experiment, keyed by factor
+	Combination map[string]string
+	// Holdout is the key of the holdout of the participant, if any
+	Holdout string
+	// Failure is the action taken when the persistence failed, which can be FailOpen when the
+	// policy is ResetAndReassign but there was nothing to reset. FailureError is what failed
+	Failure      FailurePolicy
+	FailureError error
 }
 
 type FinishExperimentResponse struct {
 	DidFinish          bool
 	DidFinishFirstTime bool
 	Alternative        string
+	// Combination is the alternative of every factor of a multivariate experiment, keyed by factor
+	Combination map[string]string
+	// Holdout is the key of the holdout of the participant, if any. Held out participants never finish
+	Holdout string
+	// Propagated is set when the alternative was assigned by an upstream service. The participant
+	// is enrolled and tracked there, so the completion is left to the upstream service
+	Propagated bool
+	// Failure is the action taken when the persistence failed, FailureError is what failed
+	Failure      FailurePolicy
+	FailureError error
+}
+
+// served returns the copy of the experiment that is served, with the alternatives generated
+// from the factors and the missing weights set to 1. The factors and the alternatives are
+// copied so that the caller cannot change them afterwards
+func (e Experiment) served() Experiment {
+	e.Factors = slices.Clone(e.Factors)
+	e = e.expandFactors()
+
+	e.Alternatives = slices.Clone(e.Alternatives)
+	for i := range e.Alternatives {
+		if e.Alternatives[i].Weight == 0 {
+			e.Alternatives[i].Weight = 1
+		}
+	}
+
+	return e
+}
+
+// ServedAlternatives returns the alternatives participants are served with their weights, the
+// ones of a multivariate experiment are the combinations of its factors
+func (e Experiment) ServedAlternatives() Alternatives {
+	return e.served().Alternatives
 }
 
 func (e Experiment) getFirstAlternative() string {
 	return e.Alternatives[0].Name
 }
 
+// scheduleExclusion checks whether the experiment enrolls participants at the given time
+func (e Experiment) scheduleExclusion(now time.Time) ExclusionReason {
+	if !e.StartsAt.IsZero() && now.Before(e.StartsAt) {
+		return ExcludedNotStarted
+	}
+	if !e.EndsAt.IsZero() && !now.Before(e.EndsAt) {
+		return ExcludedEnded
+	}
+
+	return ""
+}
+
+// enrollmentExclusion checks whether the experiment enrolls participants at the given time,
+// either because of its schedule or because it is paused
+func (e Experiment) enrollmentExclusion(now time.Time) ExclusionReason {
+	if reason := e.scheduleExclusion(now); len(reason) > 0 {
+		return reason
+	}
+	if e.Paused {
+		return ExcludedPaused
+	}
+
+	return ""
+}
+
+// getServedAlternative returns the alternative served to participants that are not
+// enrolled, which is the winner of an ended experiment or the first alternative
+func (e Experiment) getServedAlternative(reason ExclusionReason) string {
+	if reason == ExcludedEnded && len(e.Winner) > 0 {
+		return e.Winner
+	}
+
+	return e.getFirstAlternative()
+}
+
+func (e Experiment) getPayload(name string) json.RawMessage {
+	for _, a := range e.Alternatives {
+		if a.Name == name {
+			return a.Payload
+		}
+	}
+
+	return nil
+}
+
+func (e Experiment) hasAlternative(name string) bool {
+	for _, a := range e.Alternatives {
+		if a.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// policySince returns the most disruptive policy of all the versions that were
+// introduced after the given version
+func (e Experiment) policySince(version int) VersionPolicy {
+	policy := KeepAssignments
+	for v, p := range e.VersionPolicies {
+		if v > version && v <= e.Version && p.strength() > policy.strength() {
+			policy = p
+		}
+	}
+
+	return policy
+}
+
+// resolveAssignment checks an alternative that was assigned under the given version against
+// the current version of the experiment. It returns whether the participant must be assigned
+// again, or whether they are no longer enrolled because their alternative was removed and
+// the policy keeps the assignments. Either way they must not be credited to the alternative
+func (e Experiment) resolveAssignment(alternative string, version int) (reassign, removed bool) {
+	policy := KeepAssignments
+	if version < e.Version {
+		policy = e.policySince(version)
+	}
+
+	switch {
+	case policy == RestartExperiment:
+		return true, false
+	case e.hasAlternative(alternative):
+		return false, false
+	case policy == ReassignRemoved:
+		return true, false
+	default:
+		return false, true
+	}
+}
+
+func (e Experiment) totalWeight() int {
+	total := 0
+	for _, a := range e.Alternatives {
+		total += a.Weight
+	}
+
+	return total
+}
+
+// alternativeAt returns the alternative that owns the position, alternatives own as many
+// consecutive positions as their weight
+func (e Experiment) alternativeAt(position int) string {
+	for _, a := range e.Alternatives {
+		if position < a.Weight {
+			return a.Name
+		}
+		position -= a.Weight
+	}
+
+	return e.getFirstAlternative()
+}
+
 // chooseAlternative returns a random variant from the variants of the experiment
 // based on the weights
 func (e Experiment) chooseAlternative() string {
-	sumWeights := 0
-	for _, a := range e.Alternatives {
-		sumWeights += a.Weight
-	}
-	point := rand.Float64() * float64(sumWeights)
+	point := rand.Float64() * float64(e.totalWeight())
 
 	for _, a := range e.Alternatives {
 		if point <= float64(a.Weight) {
@@ -1,7 +1,187 @@
 package swole
 
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// ExperimentStore holds experiments that are managed outside of the code, for example
+// with the swole command line tool. See ExperimentManager.ReloadExperiments
 type ExperimentStore interface {
 	Get(key string) (Experiment, bool, error)
 	Set(key string, exp Experiment) error
 	Delete(key string) error
+	// List returns every experiment of the store sorted by key
+	List() ([]Experiment, error)
+}
+
+// MemoryExperimentStore keeps the experiments in memory, it is safe for concurrent use
+type MemoryExperimentStore struct {
+	mu          sync.Mutex
+	experiments map[string]Experiment
+}
+
+func NewMemoryExperimentStore() *MemoryExperimentStore {
+	return &MemoryExperimentStore{
+		experiments: make(map[string]Experiment),
+	}
+}
+
+func (s *MemoryExperimentStore) Get(key string) (Experiment, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	experiment, found := s.experiments[key]
+
+	return experiment, found, nil
+}
+
+func (s *MemoryExperimentStore) Set(key string, exp Experiment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exp.Key = key
+	s.experiments[key] = exp
+
+	return nil
+}
+
+func (s *MemoryExperimentStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.experiments, key)
+
+	return nil
+}
+
+func (s *MemoryExperimentStore) List() ([]Experiment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return sortedExperiments(s.experiments), nil
+}
+
+// FileExperimentStore keeps the experiments in a JSON file. Every write replaces the file
+// atomically so that readers, like other instances of the application polling it,
+// never see a partial write. A missing file is an empty store
+type FileExperimentStore struct {
+	mu   sync.Mutex
+	Path string
+}
+
+func NewFileExperimentStore(path string) *FileExperimentStore {
+	return &FileExperimentStore{Path: path}
+}
+
+func (s *FileExperimentStore) Get(key string) (Experiment, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	experiments, err := s.read()
+	if err != nil {
+		return Experiment{}, false, err
+	}
+	experiment, found := experiments[key]
+
+	return experiment, found, nil
+}
+
+func (s *FileExperimentStore) Set(key string, exp Experiment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	experiments, err := s.read()
+	if err != nil {
+		return err
+	}
+	exp.Key = key
+	experiments[key] = exp
+
+	return s.write(experiments)
+}
+
+func (s *FileExperimentStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	experiments, err := s.read()
+	if err != nil {
+		return err
+	}
+	delete(experiments, key)
+
+	return s.write(experiments)
+}
+
+func (s *FileExperimentStore) List() ([]Experiment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	experiments, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return sortedExperiments(experiments), nil
+}
+
+func (s *FileExperimentStore) read() (map[string]Experiment, error) {
+	experiments := make(map[string]Experiment)
+
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return experiments, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Experiment
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		return nil, err
+	}
+	for _, experiment := range list {
+		experiments[experiment.Key] = experiment
+	}
+
+	return experiments, nil
+}
+
+func (s *FileExperimentStore) write(experiments map[string]Experiment) error {
+	data, err := json.MarshalIndent(sortedExperiments(experiments), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(append(data, '\n'))
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.Path)
+}
+
+func sortedExperiments(experiments map[string]Experiment) []Experiment {
+	return slices.SortedFunc(maps.Values(experiments), func(a, b Experiment) int {
+		return cmp.Compare(a.Key, b.Key)
+	})
 }
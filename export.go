@@ -0,0 +1,160 @@
+package swole
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"time"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported export format")
+
+// EventFilter selects the events to export, zero values match everything
+type EventFilter struct {
+	Experiment   string
+	Types        []EventType
+	Alternatives []string
+	// From is inclusive and To is exclusive
+	From time.Time
+	To   time.Time
+}
+
+func (f EventFilter) Match(e Event) bool {
+	if len(f.Experiment) > 0 && e.Experiment != f.Experiment {
+		return false
+	}
+	if len(f.Types) > 0 && !slices.Contains(f.Types, e.Type) {
+		return false
+	}
+	if len(f.Alternatives) > 0 && !slices.Contains(f.Alternatives, e.Alternative) {
+		return false
+	}
+	if !f.From.IsZero() && e.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !e.Time.Before(f.To) {
+		return false
+	}
+
+	return true
+}
+
+// EventWriter writes exported events in a specific format
+type EventWriter interface {
+	Write(event Event) error
+	// Flush writes anything that is buffered, it must be called once all the events are written
+	Flush() error
+}
+
+// NewEventWriter returns a writer for one of the built in formats, `csv` or `jsonl`. Parquet is
+// written by the EventWriter of the swoleparquet module, so that the core library has no dependencies
+func NewEventWriter(w io.Writer, format string) (EventWriter, error) {
+	switch format {
+	case "csv":
+		return NewCSVEventWriter(w), nil
+	case "jsonl":
+		return NewJSONLinesEventWriter(w), nil
+	default:
+		return nil, fmt.Errorf("%w: `%s`", ErrUnsupportedFormat, format)
+	}
+}
+
+type csvEventWriter struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+// NewCSVEventWriter writes the events as CSV with a header row, times are in RFC 3339
+func NewCSVEventWriter(w io.Writer) EventWriter {
+	return &csvEventWriter{w: csv.NewWriter(w)}
+}
+
+func (c *csvEventWriter) Write(event Event) error {
+	if !c.headerWritten {
+		c.headerWritten = true
+		err := c.w.Write([]string{"type", "time", "experiment", "alternative", "subject", "goal", "value", "failure", "holdout"})
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.w.Write([]string{
+		string(event.Type),
+		event.Time.Format(time.RFC3339Nano),
+		event.Experiment,
+		event.Alternative,
+		event.Subject,
+		event.Goal,
+		csvValue(event),
+		string(event.Failure),
+		event.Holdout,
+	})
+}
+
+// csvValue leaves the value empty for events that do not carry one
+func csvValue(event Event) string {
+	if event.Type != EventValue && event.Type != EventSampleRatioMismatch {
+		return ""
+	}
+
+	return strconv.FormatFloat(event.Value, 'f', -1, 64)
+}
+
+func (c *csvEventWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonLinesEventWriter struct {
+	buf     *bufio.Writer
+	encoder *json.Encoder
+}
+
+// NewJSONLinesEventWriter writes every event as a line of JSON
+func NewJSONLinesEventWriter(w io.Writer) EventWriter {
+	buf := bufio.NewWriter(w)
+
+	return &jsonLinesEventWriter{buf: buf, encoder: json.NewEncoder(buf)}
+}
+
+func (j *jsonLinesEventWriter) Write(event Event) error {
+	return j.encoder.Encode(event)
+}
+
+func (j *jsonLinesEventWriter) Flush() error {
+	return j.buf.Flush()
+}
+
+// ExportEvents reads the events written by a JSONLinesEventSink one at a time and writes the
+// ones that match the filter, so exports never need to hold all the events in memory
+func ExportEvents(r io.Reader, filter EventFilter, w EventWriter) (exported int, err error) {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+
+	for read := 1; ; read++ {
+		var event Event
+		err := decoder.Decode(&event)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return exported, fmt.Errorf("cannot read event %d: %w", read, err)
+		}
+
+		if !filter.Match(event) {
+			continue
+		}
+
+		err = w.Write(event)
+		if err != nil {
+			return exported, err
+		}
+		exported++
+	}
+
+	return exported, w.Flush()
+}
@@ -0,0 +1,41 @@
+package swole
+
+// FailurePolicy decides what happens when the persistence of the participants fails,
+// for example when the cookie holds invalid JSON or grew too long
+type FailurePolicy string
+
+const (
+	// FailClosed returns the error to the caller, it is the default
+	FailClosed FailurePolicy = "fail_closed"
+	// FailOpen serves the first alternative without enrolling the participant
+	FailOpen FailurePolicy = "fail_open"
+	// ResetAndReassign drops the corrupted state of the participant and assigns them again.
+	// Failures that leave nothing to reset fall back to FailOpen and are reported as such, like
+	// when the lifetime of a valid assignment cannot be extended or no identity can be created
+	ResetAndReassign FailurePolicy = "reset_and_reassign"
+)
+
+func (p FailurePolicy) failsClosed() bool {
+	return p != FailOpen && p != ResetAndReassign
+}
+
+// degradedStart is the response served when the policy is to fail open
+func degradedStart(experiment Experiment, err error) *StartExperimentResponse {
+	alternative := experiment.getFirstAlternative()
+
+	return &StartExperimentResponse{
+		Alternative:  alternative,
+		Payload:      experiment.getPayload(alternative),
+		Failure:      FailOpen,
+		FailureError: err,
+	}
+}
+
+// degradedFinish is the response served when the participant cannot be finished
+func degradedFinish(alternative string, failure FailurePolicy, err error) *FinishExperimentResponse {
+	return &FinishExperimentResponse{
+		Alternative:  alternative,
+		Failure:      failure,
+		FailureError: err,
+	}
+}
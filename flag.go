@@ -0,0 +1,217 @@
+package swole
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+)
+
+type subjectContextKey struct{}
+
+// WithSubject returns a copy of the context that carries the identity of the subject
+func WithSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, subjectContextKey{}, subject)
+}
+
+// SubjectFromContext returns the identity of the subject carried by the context
+func SubjectFromContext(ctx context.Context) (string, bool) {
+	subject, ok := ctx.Value(subjectContextKey{}).(string)
+
+	return subject, ok && len(subject) > 0
+}
+
+// Flag is a feature flag. A boolean flag has no variants while a multivariate flag
+// serves one of its variants to every subject it is enabled for
+type Flag struct {
+	Key string
+	// Variants reuse the alternative model, the weights decide how the subjects
+	// the flag is enabled for are split between them
+	Variants Alternatives
+	// Rollout is the percentage of subjects the flag is enabled for, between 0 and 100.
+	// Without a subject in the context the flag is enabled only on a full rollout
+	Rollout int
+	// Allow are the subjects the flag is always enabled for
+	Allow []string
+	// Deny are the subjects the flag is never enabled for
+	Deny []string
+	// Killed disables the flag for everyone, including the allowed subjects
+	Killed bool
+}
+
+// enabledFor decides whether the flag is enabled for the subject, an empty subject is anonymous
+func (f Flag) enabledFor(subject string) bool {
+	if f.Killed {
+		return false
+	}
+
+	if len(subject) == 0 {
+		return f.Rollout >= 100
+	}
+
+	if slices.Contains(f.Deny, subject) {
+		return false
+	}
+	if slices.Contains(f.Allow, subject) {
+		return true
+	}
+
+	return bucket("flag:"+f.Key, subject, 100) < f.Rollout
+}
+
+// variantFor deterministically picks one of the variants for the subject based on the weights
+func (f Flag) variantFor(subject string) string {
+	sumWeights := 0
+	for _, v := range f.Variants {
+		sumWeights += v.Weight
+	}
+	point := bucket("variant:"+f.Key, subject, sumWeights)
+
+	for _, v := range f.Variants {
+		if point < v.Weight {
+			return v.Name
+		}
+		point -= v.Weight
+	}
+
+	// unreachable
+	return ""
+}
+
+func (m *ExperimentManager) getFlag(key string) (Flag, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	flag, ok := m.flags[key]
+
+	return flag, ok
+}
+
+func (m *ExperimentManager) RegisterFlag(flag Flag) error {
+	key := flag.Key
+
+	if len(key) == 0 {
+		panic(&InvalidFlagError{
+			message: "the key cannot be empty",
+			key:     key,
+		})
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, found := m.flags[key]; found {
+		panic(&InvalidFlagError{
+			message: "each flag must be registered only once",
+			key:     key,
+		})
+	}
+
+	if flag.Rollout < 0 || flag.Rollout > 100 {
+		panic(&InvalidFlagError{
+			message: "rollout must be a percentage between 0 and 100",
+			key:     key,
+		})
+	}
+
+	if len(flag.Variants) == 1 {
+		panic(&InvalidFlagError{
+			message: "a multivariate flag should have at least 2 variants",
+			key:     key,
+		})
+	}
+
+	if !unique(flag.Variants.getNames()) {
+		panic(&InvalidFlagError{
+			message: "variants must be unique",
+			key:     key,
+		})
+	}
+
+	// the variants are copied so that the caller cannot change them after registration
+	flag.Variants = slices.Clone(flag.Variants)
+	for i := range flag.Variants {
+		if flag.Variants[i].Weight < 0 {
+			panic(&InvalidFlagError{
+				message: "weights must be positive",
+				key:     key,
+			})
+		}
+
+		if flag.Variants[i].Weight == 0 {
+			flag.Variants[i].Weight = 1
+		}
+	}
+
+	m.flags[key] = flag
+
+	return nil
+}
+
+// SetFlagKilled flips the kill switch of a flag at runtime
+func (m *ExperimentManager) SetFlagKilled(key string, killed bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	flag, found := m.flags[key]
+	if !found {
+		return &FlagNotFoundError{
+			key:     key,
+			message: "SetFlagKilled failed, make sure you called `RegisterFlag` first",
+		}
+	}
+
+	flag.Killed = killed
+	m.flags[key] = flag
+
+	if m.Logger != nil {
+		m.Logger.Info("swole: flag kill switch changed", slog.String("swole.flag", key), slog.Bool("swole.killed", killed))
+	}
+
+	return nil
+}
+
+// IsEnabled reports whether the flag is enabled for the subject of the context.
+// Unknown flags are never enabled
+func (m *ExperimentManager) IsEnabled(ctx context.Context, key string) bool {
+	flag, found := m.getFlag(key)
+	if !found {
+		return false
+	}
+
+	subject, _ := SubjectFromContext(ctx)
+
+	return flag.enabledFor(subject)
+}
+
+// FlagVariant returns the variant of a multivariate flag for the subject of the context,
+// enabled is false when the flag is not enabled for the subject or has no variants
+func (m *ExperimentManager) FlagVariant(ctx context.Context, key string) (variant string, enabled bool) {
+	flag, found := m.getFlag(key)
+	if !found || len(flag.Variants) == 0 {
+		return "", false
+	}
+
+	subject, _ := SubjectFromContext(ctx)
+	if !flag.enabledFor(subject) {
+		return "", false
+	}
+
+	// anonymous subjects of a fully rolled out flag all get the first variant
+	if len(subject) == 0 {
+		return flag.Variants[0].Name, true
+	}
+
+	return flag.variantFor(subject), true
+}
+
+// RequestContext returns the context of the request carrying the identity of the participant
+// from the persistence store, so that flags are evaluated consistently with the experiments
+func (m *ExperimentManager) RequestContext(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	id, err := m.PersistenceStore.Identity(w, r)
+	if err != nil {
+		return nil, err
+	}
+
+	return WithSubject(r.Context(), id), nil
+}
@@ -0,0 +1,207 @@
+package swole
+
+import (
+	"context"
+	"slices"
+)
+
+// HoldoutBuckets is the number of buckets participants are hashed into by the holdouts,
+// every bucket holds 0.01% of the participants
+const HoldoutBuckets = 10000
+
+// Holdout is a stable group of participants that is served the control of every experiment.
+// Held out participants are never enrolled, they are tracked as a group of their own so that
+// the cumulative impact of the experiments can be measured against them
+type Holdout struct {
+	Key string
+	// Buckets are the buckets of the participants that are held out, [0, 500) holds out 5% of them
+	Buckets BucketRange
+	// Experiments limits the holdout to these experiments, empty means every experiment
+	Experiments []string
+}
+
+func (h Holdout) appliesTo(key string) bool {
+	return len(h.Experiments) == 0 || slices.Contains(h.Experiments, key)
+}
+
+// trackingKey is the key the held out participants of the experiment are persisted and
+// tracked under, keeping them apart from the participants of the experiment
+func (h Holdout) trackingKey(experiment string) string {
+	return experiment + "@" + h.Key
+}
+
+// RegisterHoldout registers a holdout, it should be registered before the experiments it applies
+// to start since participants that were already enrolled are held out from then on
+func (m *ExperimentManager) RegisterHoldout(holdout Holdout) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := holdout.Key
+
+	if len(key) == 0 {
+		panic(&InvalidHoldoutError{
+			message: "the key cannot be empty",
+			key:     key,
+		})
+	}
+
+	for _, h := range m.holdouts {
+		if h.Key == key {
+			panic(&InvalidHoldoutError{
+				message: "each holdout must be registered only once",
+				key:     key,
+			})
+		}
+	}
+
+	buckets := holdout.Buckets
+	if buckets.Start < 0 || buckets.End > HoldoutBuckets || buckets.Start >= buckets.End {
+		panic(&InvalidHoldoutError{
+			message: "bucket range is not valid",
+			key:     key,
+		})
+	}
+
+	holdout.Experiments = slices.Clone(holdout.Experiments)
+	m.holdouts = append(m.holdouts, holdout)
+
+	return nil
+}
+
+// getHoldouts returns the holdouts that apply to the experiment
+func (m *ExperimentManager) getHoldouts(key string) []Holdout {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var holdouts []Holdout
+	for _, h := range m.holdouts {
+		if h.appliesTo(key) {
+			holdouts = append(holdouts, h)
+		}
+	}
+
+	return holdouts
+}
+
+// holdoutFor returns the holdout of the participant for the experiment, if any. The identity
+// is created when the experiment has holdouts, like it is for layered experiments
+func (m *ExperimentManager) holdoutFor(key string, p participant, createIdentity bool) (Holdout, bool, error) {
+	holdouts := m.getHoldouts(key)
+	if len(holdouts) == 0 {
+		return Holdout{}, false, nil
+	}
+
+	id := p.knownIdentity()
+	if len(id) == 0 && createIdentity {
+		var err error
+		id, err = p.identity()
+		if err != nil {
+			return Holdout{}, false, err
+		}
+	}
+	if len(id) == 0 {
+		return Holdout{}, false, nil
+	}
+
+	for _, h := range holdouts {
+		if h.Buckets.contains(bucket(h.Key, id, HoldoutBuckets)) {
+			return h, true, nil
+		}
+	}
+
+	return Holdout{}, false, nil
+}
+
+// holdOut serves the control to a held out participant. While the experiment enrolls participants
+// the participant is tracked as part of the holdout the first time, along with the exclusion
+func (m *ExperimentManager) holdOut(ctx context.Context, experiment Experiment, holdout Holdout, p participant) (*StartExperimentResponse, error) {
+	response := &StartExperimentResponse{
+		Alternative: experiment.getFirstAlternative(),
+		Payload:     experiment.getPayload(experiment.getFirstAlternative()),
+		Excluded:    ExcludedHoldout,
+		Holdout:     holdout.Key,
+	}
+
+	if len(experiment.enrollmentExclusion(m.Now())) > 0 {
+		return response, nil
+	}
+
+	key := holdout.trackingKey(experiment.Key)
+	exists, _, _, err := p.experimentExists(key)
+	if err == nil && !exists {
+		// the holdout has no cap, so the participant is counted only once persisted
+		err = p.persistExperiment(key, response.Alternative, 0)
+		if err == nil {
+			_, err = m.trackingStore(ctx).AddParticipant(key, response.Alternative, 0)
+		}
+		if err == nil {
+			err = m.trackingStore(ctx).AddExclusion(experiment.Key, ExcludedHoldout)
+		}
+		if err == nil {
+			m.emitHoldout(ctx, EventExposure, experiment.Key, holdout, response.Alternative, p, nil)
+		}
+	}
+	if err != nil {
+		if m.FailurePolicy.failsClosed() {
+			return nil, err
+		}
+		response.Failure, response.FailureError = FailOpen, err
+	}
+
+	return response, nil
+}
+
+// finishHoldout tracks the completion and the value of a held out participant, who is never finished
+func (m *ExperimentManager) finishHoldout(ctx context.Context, experiment Experiment, holdout Holdout, p participant, value *goalValue) (*FinishExperimentResponse, error) {
+	response := &FinishExperimentResponse{
+		Alternative: experiment.getFirstAlternative(),
+		Holdout:     holdout.Key,
+	}
+
+	key := holdout.trackingKey(experiment.Key)
+	exists, _, _, err := p.experimentExists(key)
+	if err == nil && exists {
+		var finishFirstTime bool
+		finishFirstTime, err = p.experimentFinish(key)
+		if err == nil && finishFirstTime {
+			err = m.trackingStore(ctx).AddCompletion(key, response.Alternative)
+			if err == nil {
+				m.emitHoldout(ctx, EventGoal, experiment.Key, holdout, response.Alternative, p, nil)
+			}
+		}
+		if err == nil && value != nil {
+			err = m.trackingStore(ctx).AddValue(key, response.Alternative, value.goal, value.value)
+			if err == nil {
+				m.emitHoldout(ctx, EventValue, experiment.Key, holdout, response.Alternative, p, value)
+			}
+		}
+	}
+	if err != nil {
+		if m.FailurePolicy.failsClosed() {
+			return nil, err
+		}
+		response.Failure, response.FailureError = FailOpen, err
+	}
+
+	return response, nil
+}
+
+// holdoutResults returns the results of the holdouts of the experiment
+func (m *ExperimentManager) holdoutResults(experiment Experiment) ([]AlternativeResult, error) {
+	var results []AlternativeResult
+	for _, h := range m.getHoldouts(experiment.Key) {
+		counts, err := m.TrackingStore.Counts(h.trackingKey(experiment.Key))
+		if err != nil {
+			return nil, err
+		}
+
+		c := counts[experiment.getFirstAlternative()]
+		results = append(results, AlternativeResult{
+			Name:         h.Key,
+			Participants: c.Participants,
+			Completions:  c.Completions,
+		})
+	}
+
+	return results, nil
+}
@@ -0,0 +1,84 @@
+package swole
+
+import (
+	"context"
+	"net/http"
+)
+
+type httpContextKey struct{}
+
+type httpExchange struct {
+	w http.ResponseWriter
+	r *http.Request
+}
+
+// WithHTTP returns a copy of the context that carries the response writer and the request,
+// it lets an HTTPAssignmentStore reach the participant of the request
+func WithHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+	return context.WithValue(ctx, httpContextKey{}, httpExchange{w: w, r: r})
+}
+
+func httpFromContext(ctx context.Context) (http.ResponseWriter, *http.Request, error) {
+	exchange, ok := ctx.Value(httpContextKey{}).(httpExchange)
+	if !ok {
+		return nil, nil, ErrMissingHTTP
+	}
+
+	return exchange.w, exchange.r, nil
+}
+
+// HTTPAssignmentStore adapts a PersistenceStore to an AssignmentStore. The participant is the
+// one of the request carried by the context, see WithHTTP, so the subject is ignored. The http
+// api of the manager is built on it
+type HTTPAssignmentStore struct {
+	Store PersistenceStore
+}
+
+func NewHTTPAssignmentStore(store PersistenceStore) *HTTPAssignmentStore {
+	return &HTTPAssignmentStore{Store: store}
+}
+
+func (s *HTTPAssignmentStore) ExperimentExists(ctx context.Context, subject, key string) (bool, string, int, error) {
+	w, r, err := httpFromContext(ctx)
+	if err != nil {
+		return false, "", 0, err
+	}
+
+	return s.Store.ExperimentExists(key, w, r)
+}
+
+func (s *HTTPAssignmentStore) PersistExperiment(ctx context.Context, subject, key, alternative string, version int) error {
+	w, r, err := httpFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.Store.PersistExperiment(key, alternative, version, w, r)
+}
+
+func (s *HTTPAssignmentStore) RefreshTtl(ctx context.Context, subject string) error {
+	w, r, err := httpFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.Store.RefreshTtl(w, r)
+}
+
+func (s *HTTPAssignmentStore) ExperimentFinish(ctx context.Context, subject, key string) (bool, error) {
+	w, r, err := httpFromContext(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return s.Store.ExperimentFinish(key, w, r)
+}
+
+func (s *HTTPAssignmentStore) Reset(ctx context.Context, subject string) error {
+	w, r, err := httpFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.Store.Reset(w, r)
+}
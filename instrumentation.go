@@ -0,0 +1,245 @@
+package swole
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// names of the metrics reported to the Instrumentation
+const (
+	MetricAssignments   = "swole.assignments"
+	MetricConversions   = "swole.conversions"
+	MetricExclusions    = "swole.exclusions"
+	MetricErrors        = "swole.errors"
+	MetricStoreDuration = "swole.store.duration"
+)
+
+// keys of the attributes reported to the Instrumentation
+const (
+	AttributeExperiment  = "swole.experiment"
+	AttributeAlternative = "swole.alternative"
+	AttributeExcluded    = "swole.excluded"
+	AttributeFirstTime   = "swole.first_time"
+	AttributeStore       = "swole.store"
+	AttributeOperation   = "swole.operation"
+	AttributeFailure     = "swole.failure"
+	AttributeErrorType   = "error.type"
+)
+
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// Instrumentation is notified about everything the ExperimentManager does. It mirrors the
+// tracing and metrics apis of OpenTelemetry, the swoleotel module implements it on top of them
+type Instrumentation interface {
+	// Annotate adds the attributes to the span that is active in the context
+	Annotate(ctx context.Context, attributes ...Attribute)
+	// Count adds the value to a counter
+	Count(ctx context.Context, name string, value int64, attributes ...Attribute)
+	// Record records a duration in a histogram
+	Record(ctx context.Context, name string, duration time.Duration, attributes ...Attribute)
+}
+
+// errorType classifies errors so that they can be grouped in the metrics
+func errorType(err error) string {
+	var (
+		notFoundError  *ExperimentNotFoundError
+		syntaxError    *json.SyntaxError
+		unmarshalError *json.UnmarshalTypeError
+	)
+
+	switch {
+	case errors.Is(err, ErrValueTooLong):
+		return "value_too_long"
+	case errors.Is(err, ErrMissingSubject):
+		return "missing_subject"
+	case errors.Is(err, ErrAssignmentNotFound):
+		return "assignment_not_found"
+	case errors.Is(err, ErrInvalidSignature):
+		return "invalid_signature"
+	case errors.Is(err, http.ErrNoCookie):
+		return "no_cookie"
+	case errors.As(err, &notFoundError):
+		return "experiment_not_found"
+	case errors.As(err, &syntaxError), errors.As(err, &unmarshalError):
+		return "decode"
+	default:
+		return "other"
+	}
+}
+
+func (m *ExperimentManager) observeStart(ctx context.Context, key string, response *StartExperimentResponse, err error) {
+	if err != nil {
+		m.errors.add("start", errorType(err))
+	} else if len(response.Failure) > 0 {
+		m.errors.add("start", errorType(response.FailureError))
+	}
+
+	if m.Instrumentation == nil {
+		return
+	}
+
+	if err != nil {
+		m.Instrumentation.Count(ctx, MetricErrors, 1,
+			Attribute{Key: AttributeExperiment, Value: key},
+			Attribute{Key: AttributeOperation, Value: "start"},
+			Attribute{Key: AttributeErrorType, Value: errorType(err)},
+		)
+		return
+	}
+
+	if len(response.Failure) > 0 {
+		m.Instrumentation.Count(ctx, MetricErrors, 1,
+			Attribute{Key: AttributeExperiment, Value: key},
+			Attribute{Key: AttributeOperation, Value: "start"},
+			Attribute{Key: AttributeErrorType, Value: errorType(response.FailureError)},
+			Attribute{Key: AttributeFailure, Value: string(response.Failure)},
+		)
+	}
+
+	attributes := []Attribute{
+		{Key: AttributeExperiment, Value: key},
+		{Key: AttributeAlternative, Value: response.Alternative},
+	}
+	if len(response.Excluded) > 0 {
+		attributes = append(attributes, Attribute{Key: AttributeExcluded, Value: string(response.Excluded)})
+		m.Instrumentation.Count(ctx, MetricExclusions, 1, attributes...)
+	}
+	m.Instrumentation.Annotate(ctx, append(attributes, Attribute{Key: AttributeFirstTime, Value: strconv.FormatBool(response.DidStartFirstTime)})...)
+
+	if response.DidStartFirstTime {
+		m.Instrumentation.Count(ctx, MetricAssignments, 1, attributes...)
+	}
+}
+
+func (m *ExperimentManager) observeFinish(ctx context.Context, key string, response *FinishExperimentResponse, err error) {
+	if err != nil {
+		m.errors.add("finish", errorType(err))
+	} else if len(response.Failure) > 0 {
+		m.errors.add("finish", errorType(response.FailureError))
+	}
+
+	if m.Instrumentation == nil {
+		return
+	}
+
+	if err != nil {
+		m.Instrumentation.Count(ctx, MetricErrors, 1,
+			Attribute{Key: AttributeExperiment, Value: key},
+			Attribute{Key: AttributeOperation, Value: "finish"},
+			Attribute{Key: AttributeErrorType, Value: errorType(err)},
+		)
+		return
+	}
+
+	if len(response.Failure) > 0 {
+		m.Instrumentation.Count(ctx, MetricErrors, 1,
+			Attribute{Key: AttributeExperiment, Value: key},
+			Attribute{Key: AttributeOperation, Value: "finish"},
+			Attribute{Key: AttributeErrorType, Value: errorType(response.FailureError)},
+			Attribute{Key: AttributeFailure, Value: string(response.Failure)},
+		)
+	}
+
+	attributes := []Attribute{
+		{Key: AttributeExperiment, Value: key},
+		{Key: AttributeAlternative, Value: response.Alternative},
+	}
+	m.Instrumentation.Annotate(ctx, append(attributes, Attribute{Key: AttributeFirstTime, Value: strconv.FormatBool(response.DidFinishFirstTime)})...)
+
+	if response.DidFinishFirstTime {
+		m.Instrumentation.Count(ctx, MetricConversions, 1, attributes...)
+	}
+}
+
+// recordStore records how long a store operation took
+func recordStore(ctx context.Context, instrumentation Instrumentation, store, operation string, start time.Time) {
+	instrumentation.Record(ctx, MetricStoreDuration, time.Since(start),
+		Attribute{Key: AttributeStore, Value: store},
+		Attribute{Key: AttributeOperation, Value: operation},
+	)
+}
+
+// instrumentedParticipant records the latency of the persistence of a participant
+type instrumentedParticipant struct {
+	participant
+	ctx             context.Context
+	instrumentation Instrumentation
+}
+
+func (p *instrumentedParticipant) identity() (string, error) {
+	defer recordStore(p.ctx, p.instrumentation, "persistence", "identity", time.Now())
+	return p.participant.identity()
+}
+
+func (p *instrumentedParticipant) experimentExists(key string) (bool, string, int, error) {
+	defer recordStore(p.ctx, p.instrumentation, "persistence", "experiment_exists", time.Now())
+	return p.participant.experimentExists(key)
+}
+
+func (p *instrumentedParticipant) persistExperiment(key, alternative string, version int) error {
+	defer recordStore(p.ctx, p.instrumentation, "persistence", "persist_experiment", time.Now())
+	return p.participant.persistExperiment(key, alternative, version)
+}
+
+func (p *instrumentedParticipant) refreshTtl() error {
+	defer recordStore(p.ctx, p.instrumentation, "persistence", "refresh_ttl", time.Now())
+	return p.participant.refreshTtl()
+}
+
+func (p *instrumentedParticipant) experimentFinish(key string) (bool, error) {
+	defer recordStore(p.ctx, p.instrumentation, "persistence", "experiment_finish", time.Now())
+	return p.participant.experimentFinish(key)
+}
+
+func (p *instrumentedParticipant) reset() error {
+	defer recordStore(p.ctx, p.instrumentation, "persistence", "reset", time.Now())
+	return p.participant.reset()
+}
+
+// instrumentedTrackingStore records the latency of the writes to the tracking store
+type instrumentedTrackingStore struct {
+	TrackingStore
+	ctx             context.Context
+	instrumentation Instrumentation
+}
+
+// trackingStore returns the tracking store, instrumented if needed
+func (m *ExperimentManager) trackingStore(ctx context.Context) TrackingStore {
+	if m.Instrumentation == nil {
+		return m.TrackingStore
+	}
+
+	return &instrumentedTrackingStore{TrackingStore: m.TrackingStore, ctx: ctx, instrumentation: m.Instrumentation}
+}
+
+func (s *instrumentedTrackingStore) AddParticipant(key, alternative string, limit int) (bool, error) {
+	defer recordStore(s.ctx, s.instrumentation, "tracking", "add_participant", time.Now())
+	return s.TrackingStore.AddParticipant(key, alternative, limit)
+}
+
+func (s *instrumentedTrackingStore) RemoveParticipant(key, alternative string) error {
+	defer recordStore(s.ctx, s.instrumentation, "tracking", "remove_participant", time.Now())
+	return s.TrackingStore.RemoveParticipant(key, alternative)
+}
+
+func (s *instrumentedTrackingStore) AddCompletion(key, alternative string) error {
+	defer recordStore(s.ctx, s.instrumentation, "tracking", "add_completion", time.Now())
+	return s.TrackingStore.AddCompletion(key, alternative)
+}
+
+func (s *instrumentedTrackingStore) AddValue(key, alternative, goal string, value float64) error {
+	defer recordStore(s.ctx, s.instrumentation, "tracking", "add_value", time.Now())
+	return s.TrackingStore.AddValue(key, alternative, goal, value)
+}
+
+func (s *instrumentedTrackingStore) AddExclusion(key string, reason ExclusionReason) error {
+	defer recordStore(s.ctx, s.instrumentation, "tracking", "add_exclusion", time.Now())
+	return s.TrackingStore.AddExclusion(key, reason)
+}
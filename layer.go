@@ -0,0 +1,28 @@
+package swole
+
+// Layer groups experiments that must not share participants. Every participant falls
+// in exactly one bucket of the layer and each experiment of the layer owns a range of
+// buckets, so a participant can be part of at most one experiment of the layer
+type Layer struct {
+	Key     string
+	Buckets int
+}
+
+// BucketRange is the half open range [Start, End) of the layer buckets owned by an experiment
+type BucketRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+func (b BucketRange) contains(bucket int) bool {
+	return bucket >= b.Start && bucket < b.End
+}
+
+func (b BucketRange) overlaps(other BucketRange) bool {
+	return b.Start < other.End && other.Start < b.End
+}
+
+// bucketFor returns the bucket of the layer the participant falls in
+func (l Layer) bucketFor(id string) int {
+	return bucket(l.Key, id, l.Buckets)
+}
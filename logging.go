@@ -0,0 +1,108 @@
+package swole
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+)
+
+// logAttributes returns the attributes shared by every log record of an experiment,
+// they use the same keys as the Instrumentation
+func (m *ExperimentManager) logAttributes(key string, p participant, operation string) []slog.Attr {
+	attributes := []slog.Attr{
+		slog.String(AttributeExperiment, key),
+		slog.String(AttributeOperation, operation),
+	}
+
+	if m.LogSubjects {
+		if subject := p.knownIdentity(); len(subject) > 0 {
+			attributes = append(attributes, slog.String("swole.subject", subject))
+		}
+	}
+
+	return attributes
+}
+
+// logError logs failures to decode or verify the persisted state as warnings, since the participant
+// is most likely holding a corrupted or tampered cookie, and every other failure as an error
+func (m *ExperimentManager) logError(ctx context.Context, attributes []slog.Attr, err error) {
+	attributes = append(attributes,
+		slog.String(AttributeErrorType, errorType(err)),
+		slog.Any("error", err),
+	)
+
+	if t := errorType(err); t == "decode" || t == "invalid_signature" {
+		m.Logger.LogAttrs(ctx, slog.LevelWarn, "swole: cannot decode persisted assignments", attributes...)
+		return
+	}
+
+	m.Logger.LogAttrs(ctx, slog.LevelError, "swole: experiment operation failed", attributes...)
+}
+
+// logFailure logs a persistence failure that was handled by the FailurePolicy
+func (m *ExperimentManager) logFailure(ctx context.Context, attributes []slog.Attr, failure FailurePolicy, err error) {
+	m.Logger.LogAttrs(ctx, slog.LevelWarn, "swole: persistence failed, the failure policy was applied",
+		append(attributes,
+			slog.String(AttributeFailure, string(failure)),
+			slog.String(AttributeErrorType, errorType(err)),
+			slog.Any("error", err),
+		)...,
+	)
+}
+
+func (m *ExperimentManager) logStart(ctx context.Context, key string, p participant, response *StartExperimentResponse, err error) {
+	if m.Logger == nil {
+		return
+	}
+
+	attributes := m.logAttributes(key, p, "start")
+	if err != nil {
+		m.logError(ctx, attributes, err)
+		return
+	}
+
+	attributes = append(attributes, slog.String(AttributeAlternative, response.Alternative))
+
+	if len(response.Failure) > 0 {
+		m.logFailure(ctx, attributes, response.Failure, response.FailureError)
+	}
+
+	switch {
+	case len(response.Excluded) > 0:
+		m.Logger.LogAttrs(ctx, slog.LevelDebug, "swole: participant excluded", append(attributes, slog.String(AttributeExcluded, string(response.Excluded)))...)
+	case response.Propagated:
+		m.Logger.LogAttrs(ctx, slog.LevelDebug, "swole: upstream assignment honored", attributes...)
+	case response.Reassigned:
+		m.Logger.LogAttrs(ctx, slog.LevelInfo, "swole: participant reassigned after a version change", attributes...)
+	case response.DidStartFirstTime:
+		m.Logger.LogAttrs(ctx, slog.LevelDebug, "swole: participant assigned", attributes...)
+	}
+}
+
+func (m *ExperimentManager) logFinish(ctx context.Context, key string, p participant, response *FinishExperimentResponse, err error) {
+	if m.Logger == nil {
+		return
+	}
+
+	attributes := m.logAttributes(key, p, "finish")
+	if err != nil {
+		m.logError(ctx, attributes, err)
+		return
+	}
+
+	if len(response.Failure) > 0 {
+		m.logFailure(ctx, append(attributes, slog.String(AttributeAlternative, response.Alternative)), response.Failure, response.FailureError)
+	}
+
+	switch {
+	case response.Propagated:
+		m.Logger.LogAttrs(ctx, slog.LevelDebug, "swole: upstream assignment honored", append(attributes, slog.String(AttributeAlternative, response.Alternative))...)
+	case response.DidFinish:
+		m.Logger.LogAttrs(ctx, slog.LevelDebug, "swole: participant finished",
+			append(attributes,
+				slog.String(AttributeAlternative, response.Alternative),
+				slog.String(AttributeFirstTime, strconv.FormatBool(response.DidFinishFirstTime)),
+			)...,
+		)
+	}
+}
@@ -1,138 +1,583 @@
 package swole
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"maps"
+	"math"
 	"net/http"
+	"sync"
+	"time"
 )
 
 type RegisteredExperiments map[string]Experiment
 type ExperimentManager struct {
+	// mu guards the state that can change while serving requests
+	mu                    sync.RWMutex
 	registeredExperiments RegisteredExperiments
-	// ExperimentStore  ExperimentStore
+	// storedExperiments are the experiments loaded from the ExperimentStore
+	storedExperiments RegisteredExperiments
+	layers            map[string]Layer
+	flags             map[string]Flag
+	errors            errorCounter
+	sampleRatio       sampleRatioState
+	allocations       allocationCache
+	holdouts          []Holdout
+	// payloadChecks check the payloads of the typed experiments keyed by experiment
+	payloadChecks map[string]func(Experiment) error
+	// ExperimentStore holds experiments that can be changed without a deploy, see ReloadExperiments
+	ExperimentStore ExperimentStore
+	// PersistenceStore keeps the assignments of the http api
 	PersistenceStore PersistenceStore
+	// AssignmentStore keeps the assignments of the context based api
+	AssignmentStore AssignmentStore
+	TrackingStore   TrackingStore
+	// BotDetector excludes bots from every experiment, the detection is disabled unless it is set,
+	// for example to NewBotDetector()
+	BotDetector BotDetector
+	// FailurePolicy decides what happens when the persistence fails, defaults to FailClosed
+	FailurePolicy FailurePolicy
+	// Logger logs assignments and failures, nil disables logging
+	Logger *slog.Logger
+	// LogSubjects adds the identity of the participants to the logs, it is off by default
+	// since identities can be sensitive
+	LogSubjects bool
+	// EventSink receives the raw exposure and goal events, nil disables them
+	EventSink EventSink
+	// Instrumentation is notified about every operation, nil disables it
+	Instrumentation Instrumentation
+	// SampleRatioThreshold is the p-value below which the split of the participants is a
+	// mismatch, zero means DefaultSampleRatioThreshold
+	SampleRatioThreshold float64
+	// SampleRatioCheckInterval is the number of new participants of an experiment between two
+	// automatic checks of its split, zero means DefaultSampleRatioCheckInterval and a negative
+	// value disables the automatic checks. The checks run in the background, see Wait
+	SampleRatioCheckInterval int
+	// Allocator chooses the alternatives of new participants of the experiments without their
+	// own Allocator, it defaults to WeightedRandom
+	Allocator Allocator
+	// AllocationInterval is how often the results used by the allocators of the experiments
+	// are refreshed, zero means DefaultAllocationInterval
+	AllocationInterval time.Duration
+	// Now returns the current time, it can be replaced to control the schedule of the experiments
+	Now func() time.Time
 }
 
 func (m *ExperimentManager) getExperiment(key string) (Experiment, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// experiments loaded from the ExperimentStore override the ones registered in code
+	if experiment, ok := m.storedExperiments[key]; ok {
+		return experiment, ok
+	}
 	experiment, ok := m.registeredExperiments[key]
 
 	return experiment, ok
 }
 
+func (m *ExperimentManager) getLayer(key string) Layer {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.layers[key]
+}
+
+// allExperiments merges the registered and the stored experiments, the caller must hold the lock
+func (m *ExperimentManager) allExperiments() RegisteredExperiments {
+	experiments := maps.Clone(m.registeredExperiments)
+	maps.Copy(experiments, m.storedExperiments)
+
+	return experiments
+}
+
 func NewExperimentManager() *ExperimentManager {
 	return &ExperimentManager{
 		registeredExperiments: make(RegisteredExperiments),
-		// ExperimentStore:  NewMemoryExperimentStore(),
-		PersistenceStore: NewCookiePersistenceStore(),
+		layers:                make(map[string]Layer),
+		flags:                 make(map[string]Flag),
+		payloadChecks:         make(map[string]func(Experiment) error),
+		storedExperiments:     make(RegisteredExperiments),
+		PersistenceStore:      NewCookiePersistenceStore(),
+		AssignmentStore:       NewMemoryAssignmentStore(),
+		TrackingStore:         NewMemoryTrackingStore(),
+		Allocator:             WeightedRandom{},
+		Now:                   time.Now,
 	}
 }
+
+// GetRegisterExperiments returns every experiment, including the ones loaded from the ExperimentStore
 func (m *ExperimentManager) GetRegisterExperiments() RegisteredExperiments {
-	return maps.Clone(m.registeredExperiments)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.allExperiments()
 }
 
-func (m *ExperimentManager) RegisterExperiment(experiment Experiment) error {
-	key := experiment.Key
+func (m *ExperimentManager) RegisterLayer(layer Layer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := layer.Key
 
 	if len(key) == 0 {
-		panic(&InvalidExperimentError{
+		panic(&InvalidLayerError{
 			message: "the key cannot be empty",
 			key:     key,
 		})
 	}
 
-	_, found := m.getExperiment(key)
-	if found {
-		panic(&InvalidExperimentError{
-			message: "each experiment must be registered only once",
+	if _, found := m.layers[key]; found {
+		panic(&InvalidLayerError{
+			message: "each layer must be registered only once",
 			key:     key,
 		})
 	}
 
-	if len(experiment.Alternatives) < 2 {
-		panic(&InvalidExperimentError{
-			message: "should have at least 2 alternatives",
+	if layer.Buckets < 1 {
+		panic(&InvalidLayerError{
+			message: "should have at least 1 bucket",
 			key:     key,
 		})
 	}
 
-	if !unique(experiment.Alternatives.getNames()) {
-		panic(&InvalidExperimentError{
-			message: "alternatives must be unique",
+	m.layers[key] = layer
+
+	return nil
+}
+
+// validateLayer makes sure that the experiment owns a valid range of buckets that none
+// of the other experiments of the layer owns
+func (m *ExperimentManager) validateLayer(experiment Experiment, others RegisteredExperiments) error {
+	key := experiment.Key
+
+	layer, found := m.layers[experiment.Layer]
+	if !found {
+		return &InvalidExperimentError{
+			message: fmt.Sprintf("layer `%s` is not registered, make sure you called `RegisterLayer` first", experiment.Layer),
 			key:     key,
-		})
+		}
 	}
 
-	for i := range experiment.Alternatives {
-		if experiment.Alternatives[i].Weight < 0 {
-			panic(&InvalidExperimentError{
-				message: "weights must be positive",
+	buckets := experiment.Buckets
+	if buckets.Start < 0 || buckets.End > layer.Buckets || buckets.Start >= buckets.End {
+		return &InvalidExperimentError{
+			message: fmt.Sprintf("bucket range [%d, %d) is not valid for layer `%s` with %d buckets", buckets.Start, buckets.End, layer.Key, layer.Buckets),
+			key:     key,
+		}
+	}
+
+	for _, other := range others {
+		if other.Key != key && other.Layer == experiment.Layer && other.Buckets.overlaps(buckets) {
+			return &InvalidExperimentError{
+				message: fmt.Sprintf("bucket range overlaps with experiment `%s` of layer `%s`", other.Key, layer.Key),
 				key:     key,
-			})
+			}
 		}
+	}
+
+	return nil
+}
+
+// prepareExperiment validates the experiment against the other experiments and
+// returns the copy of it that is served
+func (m *ExperimentManager) prepareExperiment(experiment Experiment, others RegisteredExperiments) (Experiment, error) {
+	err := experiment.Validate()
+	if err != nil {
+		return Experiment{}, err
+	}
 
-		if experiment.Alternatives[i].Weight == 0 {
-			experiment.Alternatives[i].Weight = 1
+	if len(experiment.Layer) > 0 {
+		err = m.validateLayer(experiment, others)
+		if err != nil {
+			return Experiment{}, err
 		}
 	}
 
+	return experiment.served(), nil
+}
+
+func (m *ExperimentManager) RegisterExperiment(experiment Experiment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := experiment.Key
+
+	_, found := m.registeredExperiments[key]
+	if found {
+		panic(&InvalidExperimentError{
+			message: "each experiment must be registered only once",
+			key:     key,
+		})
+	}
+
+	experiment, err := m.prepareExperiment(experiment, m.allExperiments())
+	if err != nil {
+		panic(err)
+	}
+
 	m.registeredExperiments[key] = experiment
 
 	return nil
 }
 
+// StartExperiment enrolls the participant of the http request in the experiment, the assignment
+// is kept by the PersistenceStore through an HTTPAssignmentStore
 func (m *ExperimentManager) StartExperiment(key string, w http.ResponseWriter, r *http.Request) (*StartExperimentResponse, error) {
+	return m.start(r.Context(), "StartExperiment", key, m.requestParticipant(w, r))
+}
+
+// Start enrolls the subject in the experiment, the assignment is kept by the AssignmentStore
+func (m *ExperimentManager) Start(ctx context.Context, subject, key string) (*StartExperimentResponse, error) {
+	if len(subject) == 0 {
+		return nil, ErrMissingSubject
+	}
+
+	return m.start(ctx, "Start", key, m.subjectParticipant(ctx, subject))
+}
+
+func (m *ExperimentManager) start(ctx context.Context, operation, key string, p participant) (response *StartExperimentResponse, err error) {
+	var experiment Experiment
+	defer func() {
+		if response != nil {
+			response.Combination = experiment.combination(response.Alternative)
+		}
+		m.observeStart(ctx, key, response, err)
+		m.logStart(ctx, key, p, response, err)
+		m.emitStart(ctx, key, p, response, err)
+		m.watchSampleRatio(ctx, key, response, err)
+	}()
+
 	experiment, found := m.getExperiment(key)
 	if !found {
 		return nil, &ExperimentNotFoundError{
 			key:     key,
-			message: "StartExperiment failed, make sure you called `RegisterExperiment` first",
+			message: operation + " failed, make sure you called `RegisterExperiment` first",
 		}
 	}
 
-	exists, alternative, err := m.PersistenceStore.ExperimentExists(key, w, r)
+	// bots are not tracked at all, their exclusion is only counted
+	if p.isBot() {
+		return m.exclude(ctx, experiment, ExcludedBot)
+	}
+
+	// the upstream service already enrolled and tracked the participant
+	if alternative, found := propagatedAssignment(ctx, experiment, p); found {
+		// the experiment might not run here, the upstream service tracked the exclusion if any
+		if reason := experiment.scheduleExclusion(m.Now()); len(reason) > 0 {
+			served := experiment.getServedAlternative(reason)
+			return &StartExperimentResponse{
+				Alternative: served,
+				Payload:     experiment.getPayload(served),
+				Excluded:    reason,
+				Propagated:  true,
+			}, nil
+		}
+
+		return &StartExperimentResponse{
+			Alternative: alternative,
+			Payload:     experiment.getPayload(alternative),
+			DidStart:    true,
+			Propagated:  true,
+		}, nil
+	}
+
+	// held out participants get the control even after the experiment ends
+	holdout, heldOut, err := m.holdoutFor(key, p, true)
 	if err != nil {
-		return nil, err
+		if m.FailurePolicy.failsClosed() {
+			return nil, err
+		}
+		return degradedStart(experiment, err), nil
+	}
+	if heldOut {
+		return m.holdOut(ctx, experiment, holdout, p)
+	}
+
+	if reason := experiment.scheduleExclusion(m.Now()); len(reason) > 0 {
+		return m.exclude(ctx, experiment, reason)
+	}
+
+	if len(experiment.Layer) > 0 {
+		id, err := p.identity()
+		if err != nil {
+			if m.FailurePolicy.failsClosed() {
+				return nil, err
+			}
+			// without an identity there is nothing to reset, so the participant can only be served the control
+			return degradedStart(experiment, err), nil
+		}
+
+		// participants of the other experiments of the layer are never enrolled
+		if !experiment.Buckets.contains(m.getLayer(experiment.Layer).bucketFor(id)) {
+			return m.exclude(ctx, experiment, ExcludedByLayer)
+		}
+	}
+
+	var (
+		failure      FailurePolicy
+		failureError error
+	)
+
+	exists, alternative, version, err := p.experimentExists(key)
+	if err != nil {
+		switch m.FailurePolicy {
+		case FailOpen:
+			return degradedStart(experiment, err), nil
+		case ResetAndReassign:
+			resetErr := p.reset()
+			if resetErr != nil {
+				return nil, errors.Join(err, resetErr)
+			}
+			exists, failure, failureError = false, ResetAndReassign, err
+		default:
+			return nil, err
+		}
 	}
-	if !exists {
-		alternative = experiment.chooseAlternative()
-		err = m.PersistenceStore.PersistExperiment(key, alternative, w, r)
+
+	reassign, removed := false, false
+	if exists {
+		reassign, removed = experiment.resolveAssignment(alternative, version)
+	}
+	if removed {
+		return m.exclude(ctx, experiment, ExcludedAlternativeRemoved)
+	}
+
+	if !exists || reassign {
+		// paused experiments keep serving the participants that are already enrolled
+		if experiment.Paused {
+			return m.exclude(ctx, experiment, ExcludedPaused)
+		}
+
+		previous := alternative
+		if reassign {
+			// the participant is counted again under their new alternative
+			err = m.trackingStore(ctx).RemoveParticipant(key, previous)
+			if err != nil {
+				return nil, err
+			}
+		}
+		// restore counts the participant under their previous alternative again when the
+		// reassignment fails, since it is attempted again on their next start
+		restore := func(err error) error {
+			if !reassign {
+				return err
+			}
+			_, restoreErr := m.trackingStore(ctx).AddParticipant(key, previous, 0)
+			return errors.Join(err, restoreErr)
+		}
+
+		alternative = m.allocate(ctx, experiment, p)
+
+		added, err := m.trackingStore(ctx).AddParticipant(key, alternative, experiment.MaxParticipants)
 		if err != nil {
+			return nil, restore(err)
+		}
+		if !added {
+			if err := restore(nil); err != nil {
+				return nil, err
+			}
+			return m.exclude(ctx, experiment, ExcludedCapReached)
+		}
+
+		err = p.persistExperiment(key, alternative, experiment.Version)
+		if err != nil && m.FailurePolicy == ResetAndReassign {
+			// the state might have grown too large, so it is persisted again from scratch
+			failure, failureError = ResetAndReassign, err
+			err = p.reset()
+			if err == nil {
+				err = p.persistExperiment(key, alternative, experiment.Version)
+			}
+		}
+		if err != nil {
+			// the participant was counted but is not enrolled, so the slot is given back
+			removeErr := restore(m.trackingStore(ctx).RemoveParticipant(key, alternative))
+			if removeErr != nil {
+				return nil, errors.Join(err, removeErr)
+			}
+			if m.FailurePolicy == FailOpen {
+				return degradedStart(experiment, err), nil
+			}
 			return nil, err
 		}
 		return &StartExperimentResponse{
 			Alternative:       alternative,
+			Payload:           experiment.getPayload(alternative),
 			DidStart:          true,
 			DidStartFirstTime: true,
+			Reassigned:        reassign,
+			Failure:           failure,
+			FailureError:      failureError,
 		}, nil
 	}
 
 	// here experiment exists
-	err = m.PersistenceStore.RefreshTtl(w, r)
+	err = p.refreshTtl()
 	if err != nil {
-		return nil, err
+		if m.FailurePolicy.failsClosed() {
+			return nil, err
+		}
+		// the assignment is still valid, it just could not be extended, so even ResetAndReassign
+		// serves it as it is
+		failure, failureError = FailOpen, err
 	}
 
 	return &StartExperimentResponse{
 		Alternative:       alternative,
+		Payload:           experiment.getPayload(alternative),
 		DidStart:          true,
 		DidStartFirstTime: false,
+		Failure:           failure,
+		FailureError:      failureError,
 	}, nil
 }
 
+// exclude tracks that the participant was not enrolled and returns the alternative they should be served
+func (m *ExperimentManager) exclude(ctx context.Context, experiment Experiment, reason ExclusionReason) (*StartExperimentResponse, error) {
+	err := m.trackingStore(ctx).AddExclusion(experiment.Key, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	alternative := experiment.getServedAlternative(reason)
+
+	return &StartExperimentResponse{
+		Alternative: alternative,
+		Payload:     experiment.getPayload(alternative),
+		Excluded:    reason,
+	}, nil
+}
+
+// FinishExperiment marks the experiment as finished for the participant of the http request
 func (m *ExperimentManager) FinishExperiment(key string, w http.ResponseWriter, r *http.Request) (*FinishExperimentResponse, error) {
+	return m.finish(r.Context(), "FinishExperiment", key, m.requestParticipant(w, r), nil)
+}
+
+// FinishExperimentWithValue finishes the experiment and records a numeric value of the goal,
+// like the value of an order. Unlike the completion the value is recorded on every call
+func (m *ExperimentManager) FinishExperimentWithValue(key, goal string, value float64, w http.ResponseWriter, r *http.Request) (*FinishExperimentResponse, error) {
+	v, err := newGoalValue(goal, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.finish(r.Context(), "FinishExperimentWithValue", key, m.requestParticipant(w, r), v)
+}
+
+// Finish marks the experiment as finished for the subject
+func (m *ExperimentManager) Finish(ctx context.Context, subject, key string) (*FinishExperimentResponse, error) {
+	if len(subject) == 0 {
+		return nil, ErrMissingSubject
+	}
+
+	return m.finish(ctx, "Finish", key, m.subjectParticipant(ctx, subject), nil)
+}
+
+// FinishWithValue is the context based equivalent of FinishExperimentWithValue
+func (m *ExperimentManager) FinishWithValue(ctx context.Context, subject, key, goal string, value float64) (*FinishExperimentResponse, error) {
+	if len(subject) == 0 {
+		return nil, ErrMissingSubject
+	}
+	v, err := newGoalValue(goal, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.finish(ctx, "FinishWithValue", key, m.subjectParticipant(ctx, subject), v)
+}
+
+// goalValue is a numeric value recorded when an experiment is finished
+type goalValue struct {
+	goal  string
+	value float64
+}
+
+func newGoalValue(goal string, value float64) (*goalValue, error) {
+	if len(goal) == 0 {
+		return nil, ErrMissingGoal
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, ErrInvalidValue
+	}
+
+	return &goalValue{goal: goal, value: value}, nil
+}
+
+func (m *ExperimentManager) finish(ctx context.Context, operation, key string, p participant, value *goalValue) (response *FinishExperimentResponse, err error) {
+	var experiment Experiment
+	defer func() {
+		if response != nil {
+			response.Combination = experiment.combination(response.Alternative)
+		}
+		m.observeFinish(ctx, key, response, err)
+		m.logFinish(ctx, key, p, response, err)
+		m.emitFinish(ctx, key, p, value, response, err)
+	}()
+
 	experiment, found := m.getExperiment(key)
 	if !found {
 		return nil, &ExperimentNotFoundError{
 			key:     key,
-			message: "FinishExperiment failed, make sure you called `RegisterExperiment` first",
+			message: operation + " failed, make sure you called `RegisterExperiment` first",
 		}
 	}
-	exists, alternative, err := m.PersistenceStore.ExperimentExists(key, w, r)
+
+	if p.isBot() {
+		return &FinishExperimentResponse{
+			Alternative: experiment.getFirstAlternative(),
+		}, nil
+	}
+
+	// the upstream service enrolled the participant, so it is the one tracking them
+	if alternative, found := propagatedAssignment(ctx, experiment, p); found {
+		if reason := experiment.scheduleExclusion(m.Now()); len(reason) > 0 {
+			alternative = experiment.getServedAlternative(reason)
+		}
+		return &FinishExperimentResponse{
+			Alternative: alternative,
+			Propagated:  true,
+		}, nil
+	}
+
+	// the identity is not created so looking up the holdout cannot fail, participants
+	// without one were never held out
+	if holdout, heldOut, _ := m.holdoutFor(key, p, false); heldOut {
+		return m.finishHoldout(ctx, experiment, holdout, p, value)
+	}
+
+	// outside of the schedule everyone is served the same alternative, so completions can no
+	// longer be credited to the alternative the participant was enrolled in
+	if reason := experiment.scheduleExclusion(m.Now()); len(reason) > 0 {
+		return &FinishExperimentResponse{
+			Alternative: experiment.getServedAlternative(reason),
+		}, nil
+	}
+
+	exists, alternative, version, err := p.experimentExists(key)
 	if err != nil {
-		return nil, err
+		switch m.FailurePolicy {
+		case FailOpen:
+			return degradedFinish(experiment.getFirstAlternative(), FailOpen, err), nil
+		case ResetAndReassign:
+			resetErr := p.reset()
+			if resetErr != nil {
+				return nil, errors.Join(err, resetErr)
+			}
+			return degradedFinish(experiment.getFirstAlternative(), ResetAndReassign, err), nil
+		default:
+			return nil, err
+		}
 	}
 
-	// experiment does not exist therefore we shouldn't finish it
-	if !exists {
+	reassign, removed := false, false
+	if exists {
+		reassign, removed = experiment.resolveAssignment(alternative, version)
+	}
+
+	// experiment does not exist or the assignment is stale therefore we shouldn't finish it
+	if !exists || reassign || removed {
 		return &FinishExperimentResponse{
 			Alternative:        experiment.getFirstAlternative(),
 			DidFinish:          false,
@@ -140,9 +585,26 @@ func (m *ExperimentManager) FinishExperiment(key string, w http.ResponseWriter,
 		}, nil
 	}
 
-	finishFirstTime, err := m.PersistenceStore.ExperimentFinish(key, w, r)
+	finishFirstTime, err := p.experimentFinish(key)
 	if err != nil {
-		return nil, err
+		if m.FailurePolicy.failsClosed() {
+			return nil, err
+		}
+		return degradedFinish(alternative, FailOpen, err), nil
+	}
+
+	if finishFirstTime {
+		err = m.trackingStore(ctx).AddCompletion(key, alternative)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if value != nil {
+		err = m.trackingStore(ctx).AddValue(key, alternative, value.goal, value.value)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return &FinishExperimentResponse{
@@ -150,5 +612,4 @@ func (m *ExperimentManager) FinishExperiment(key string, w http.ResponseWriter,
 		DidFinish:          true,
 		DidFinishFirstTime: finishFirstTime,
 	}, nil
-
 }
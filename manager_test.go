@@ -1,12 +1,26 @@
 package swole
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestRegisterExperiment(t *testing.T) {
@@ -343,6 +357,2763 @@ func TestStartExperiment(t *testing.T) {
 	})
 }
 
+func TestExperimentVersioning(t *testing.T) {
+	tests := []struct {
+		name               string
+		cookieValue        map[string]string
+		experiment         Experiment
+		wantAlternative    string
+		wantStartFirstTime bool
+		wantExcluded       ExclusionReason
+	}{
+		{
+			name:        "Assignment without version keeps its alternative",
+			cookieValue: map[string]string{"experiment_key": "variant"},
+			experiment: Experiment{
+				Key:          "experiment_key",
+				Alternatives: Alternatives{{Name: "control"}, {Name: "variant", Weight: 5}},
+				Version:      1,
+			},
+			wantAlternative: "variant",
+		},
+		{
+			name:        "Removed alternative is excluded when kept",
+			cookieValue: map[string]string{"experiment_key": "variant", "experiment_key:version": "0"},
+			experiment: Experiment{
+				Key:             "experiment_key",
+				Alternatives:    Alternatives{{Name: "control"}, {Name: "other", Weight: 1000000}},
+				Version:         1,
+				VersionPolicies: map[int]VersionPolicy{1: KeepAssignments},
+			},
+			wantAlternative: "control",
+			wantExcluded:    ExcludedAlternativeRemoved,
+		},
+		{
+			name:        "Removed alternative is excluded without a version bump",
+			cookieValue: map[string]string{"experiment_key": "variant", "experiment_key:version": "1"},
+			experiment: Experiment{
+				Key:             "experiment_key",
+				Alternatives:    Alternatives{{Name: "control"}, {Name: "other", Weight: 1000000}},
+				Version:         1,
+				VersionPolicies: map[int]VersionPolicy{1: ReassignRemoved},
+			},
+			wantAlternative: "control",
+			wantExcluded:    ExcludedAlternativeRemoved,
+		},
+		{
+			name:        "Removed alternative is reassigned",
+			cookieValue: map[string]string{"experiment_key": "variant", "experiment_key:version": "0"},
+			experiment: Experiment{
+				Key:             "experiment_key",
+				Alternatives:    Alternatives{{Name: "control"}, {Name: "other", Weight: 1000000}},
+				Version:         1,
+				VersionPolicies: map[int]VersionPolicy{1: ReassignRemoved},
+			},
+			wantAlternative:    "other",
+			wantStartFirstTime: true,
+		},
+		{
+			name:        "Existing alternative is kept when reassigning removed ones",
+			cookieValue: map[string]string{"experiment_key": "control", "experiment_key:version": "0"},
+			experiment: Experiment{
+				Key:             "experiment_key",
+				Alternatives:    Alternatives{{Name: "control"}, {Name: "other", Weight: 1000000}},
+				Version:         1,
+				VersionPolicies: map[int]VersionPolicy{1: ReassignRemoved},
+			},
+			wantAlternative: "control",
+		},
+		{
+			name:        "Restart from a skipped version",
+			cookieValue: map[string]string{"experiment_key": "control", "experiment_key:version": "1"},
+			experiment: Experiment{
+				Key:             "experiment_key",
+				Alternatives:    Alternatives{{Name: "control"}, {Name: "variant", Weight: 1000000}},
+				Version:         3,
+				VersionPolicies: map[int]VersionPolicy{2: RestartExperiment, 3: KeepAssignments},
+			},
+			wantAlternative:    "variant",
+			wantStartFirstTime: true,
+		},
+		{
+			name:        "Assignment under the current version is not restarted",
+			cookieValue: map[string]string{"experiment_key": "control", "experiment_key:version": "2"},
+			experiment: Experiment{
+				Key:             "experiment_key",
+				Alternatives:    Alternatives{{Name: "control"}, {Name: "variant", Weight: 1000000}},
+				Version:         2,
+				VersionPolicies: map[int]VersionPolicy{2: RestartExperiment},
+			},
+			wantAlternative: "control",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := NewExperimentManager()
+			manager.RegisterExperiment(tt.experiment)
+
+			w := httptest.NewRecorder()
+			r := newRequestWithCookieValue(t, tt.cookieValue)
+
+			response, err := manager.StartExperiment(tt.experiment.Key, w, r)
+			if err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+
+			if response.Alternative != tt.wantAlternative {
+				t.Errorf("expected alternative to be %s but got: %s", tt.wantAlternative, response.Alternative)
+			}
+
+			if response.DidStartFirstTime != tt.wantStartFirstTime {
+				t.Errorf("expected DidStartFirstTime to be %t but got %t", tt.wantStartFirstTime, response.DidStartFirstTime)
+			}
+
+			if response.Excluded != tt.wantExcluded {
+				t.Errorf("expected exclusion reason to be %s but got: %s", tt.wantExcluded, response.Excluded)
+			}
+
+			if tt.wantStartFirstTime {
+				cookieValue := getExperimentCookieValue(t, w, cookieName)
+				if cookieValue[tt.experiment.Key+":version"] != fmt.Sprint(tt.experiment.Version) {
+					t.Errorf("expected version %d to be persisted but got: %s", tt.experiment.Version, cookieValue[tt.experiment.Key+":version"])
+				}
+			}
+		})
+	}
+
+	t.Run("Reassigned participants are counted once", func(t *testing.T) {
+		ctx := context.Background()
+		manager := NewExperimentManager()
+		manager.RegisterExperiment(Experiment{
+			Key:             "experiment_key",
+			Alternatives:    Alternatives{{Name: "control"}, {Name: "variant"}},
+			MaxParticipants: 10,
+		})
+		for i := range 10 {
+			if _, err := manager.Start(ctx, fmt.Sprintf("user_%d", i), "experiment_key"); err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+		}
+
+		restarted := NewExperimentManager()
+		restarted.AssignmentStore = manager.AssignmentStore
+		restarted.TrackingStore = manager.TrackingStore
+		restarted.RegisterExperiment(Experiment{
+			Key:             "experiment_key",
+			Alternatives:    Alternatives{{Name: "control"}, {Name: "variant"}},
+			MaxParticipants: 10,
+			Version:         1,
+			VersionPolicies: map[int]VersionPolicy{1: RestartExperiment},
+		})
+		for i := range 10 {
+			response, err := restarted.Start(ctx, fmt.Sprintf("user_%d", i), "experiment_key")
+			if err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+			if !response.Reassigned {
+				t.Errorf("expected the participant to be reassigned within the cap but got: %+v", response)
+			}
+		}
+
+		results, err := restarted.GetResults("experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if results.Participants != 10 {
+			t.Errorf("expected 10 participants but got: %d", results.Participants)
+		}
+	})
+
+	t.Run("Participants of removed alternatives are not finished when kept", func(t *testing.T) {
+		ctx := context.Background()
+		manager := NewExperimentManager()
+		manager.RegisterExperiment(Experiment{
+			Key:          "experiment_key",
+			Alternatives: Alternatives{{Name: "control"}, {Name: "other"}},
+			Version:      1,
+		})
+		manager.AssignmentStore.PersistExperiment(ctx, "user_1", "experiment_key", "variant", 0)
+
+		finish, err := manager.Finish(ctx, "user_1", "experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if finish.DidFinish || finish.Alternative != "control" {
+			t.Errorf("expected the participant not to be finished but got: %+v", finish)
+		}
+	})
+
+	t.Run("Invalid version policy", func(t *testing.T) {
+		manager := NewExperimentManager()
+		assertPanic(t, func() {
+			manager.RegisterExperiment(Experiment{
+				Key:             "experiment_key",
+				Alternatives:    Alternatives{{Name: "control"}, {Name: "variant"}},
+				Version:         1,
+				VersionPolicies: map[int]VersionPolicy{2: RestartExperiment},
+			})
+		})
+	})
+}
+
+func TestExperimentLayers(t *testing.T) {
+	newManager := func() *ExperimentManager {
+		manager := NewExperimentManager()
+		manager.RegisterLayer(Layer{Key: "homepage", Buckets: 100})
+		manager.RegisterExperiment(Experiment{
+			Key:          "first_experiment",
+			Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}},
+			Layer:        "homepage",
+			Buckets:      BucketRange{Start: 0, End: 50},
+		})
+		manager.RegisterExperiment(Experiment{
+			Key:          "second_experiment",
+			Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}},
+			Layer:        "homepage",
+			Buckets:      BucketRange{Start: 50, End: 100},
+		})
+
+		return manager
+	}
+
+	t.Run("participants are part of exactly one experiment of the layer", func(t *testing.T) {
+		manager := newManager()
+
+		for range 50 {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			first, err := manager.StartExperiment("first_experiment", w, r)
+			if err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+
+			w2 := httptest.NewRecorder()
+			second, err := manager.StartExperiment("second_experiment", w2, newRequestFromResponse(w))
+			if err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+
+			if first.DidStart == second.DidStart {
+				t.Fatalf("expected participant to start exactly one experiment but got %+v and %+v", first, second)
+			}
+
+			excluded := first
+			if first.DidStart {
+				excluded = second
+			}
+			if excluded.Excluded != ExcludedByLayer {
+				t.Errorf("expected exclusion reason to be %s but got: %s", ExcludedByLayer, excluded.Excluded)
+			}
+			if excluded.Alternative != "control" {
+				t.Errorf("expected excluded participant to get control but got: %s", excluded.Alternative)
+			}
+		}
+	})
+
+	t.Run("overlapping bucket ranges", func(t *testing.T) {
+		manager := newManager()
+		assertPanic(t, func() {
+			manager.RegisterExperiment(Experiment{
+				Key:          "third_experiment",
+				Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}},
+				Layer:        "homepage",
+				Buckets:      BucketRange{Start: 40, End: 60},
+			})
+		})
+	})
+
+	t.Run("unknown layer", func(t *testing.T) {
+		manager := NewExperimentManager()
+		assertPanic(t, func() {
+			manager.RegisterExperiment(Experiment{
+				Key:          "experiment_key",
+				Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}},
+				Layer:        "homepage",
+				Buckets:      BucketRange{Start: 0, End: 10},
+			})
+		})
+	})
+}
+
+func TestExperimentSchedule(t *testing.T) {
+	athens := time.FixedZone("EET", 2*60*60)
+	startsAt := time.Date(2026, 3, 1, 9, 0, 0, 0, athens)
+	endsAt := time.Date(2026, 3, 15, 9, 0, 0, 0, athens)
+
+	tests := []struct {
+		name            string
+		now             time.Time
+		winner          string
+		wantStart       bool
+		wantExcluded    ExclusionReason
+		wantAlternative string
+	}{
+		{
+			name:            "Before the start",
+			now:             time.Date(2026, 3, 1, 6, 59, 0, 0, time.UTC),
+			wantExcluded:    ExcludedNotStarted,
+			wantAlternative: "control",
+		},
+		{
+			name:      "After the start in a different timezone",
+			now:       time.Date(2026, 3, 1, 7, 0, 0, 0, time.UTC),
+			wantStart: true,
+		},
+		{
+			name:            "After the end without a winner",
+			now:             endsAt,
+			wantExcluded:    ExcludedEnded,
+			wantAlternative: "control",
+		},
+		{
+			name:            "After the end with a winner",
+			now:             endsAt.Add(time.Hour),
+			winner:          "variant",
+			wantExcluded:    ExcludedEnded,
+			wantAlternative: "variant",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := NewExperimentManager()
+			manager.Now = func() time.Time { return tt.now }
+			manager.RegisterExperiment(Experiment{
+				Key:          "experiment_key",
+				Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}},
+				StartsAt:     startsAt,
+				EndsAt:       endsAt,
+				Winner:       tt.winner,
+			})
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			response, err := manager.StartExperiment("experiment_key", w, r)
+			if err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+
+			if response.DidStart != tt.wantStart {
+				t.Errorf("expected DidStart to be %t but got %t", tt.wantStart, response.DidStart)
+			}
+
+			if response.Excluded != tt.wantExcluded {
+				t.Errorf("expected exclusion reason to be %s but got: %s", tt.wantExcluded, response.Excluded)
+			}
+
+			if !tt.wantStart {
+				if response.Alternative != tt.wantAlternative {
+					t.Errorf("expected alternative to be %s but got: %s", tt.wantAlternative, response.Alternative)
+				}
+				if len(w.Result().Cookies()) != 0 {
+					t.Error("expected no cookies to be written")
+				}
+			}
+		})
+	}
+
+	t.Run("Finish after the end", func(t *testing.T) {
+		now := startsAt
+		manager := NewExperimentManager()
+		manager.Now = func() time.Time { return now }
+		manager.RegisterExperiment(Experiment{
+			Key:          "experiment_key",
+			Alternatives: Alternatives{{Name: "control", Weight: 1000000}, {Name: "variant"}},
+			StartsAt:     startsAt,
+			EndsAt:       endsAt,
+			Winner:       "variant",
+		})
+
+		ctx := context.Background()
+		response, err := manager.Start(ctx, "user_1", "experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if response.Alternative != "control" {
+			t.Fatalf("expected the control but got: %s", response.Alternative)
+		}
+
+		now = endsAt
+		finish, err := manager.Finish(ctx, "user_1", "experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if finish.DidFinish || finish.Alternative != "variant" {
+			t.Errorf("expected the winner that was served not to be finished but got: %+v", finish)
+		}
+
+		results, err := manager.GetResults("experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if results.Completions != 0 {
+			t.Errorf("expected no completions after the end but got: %d", results.Completions)
+		}
+	})
+}
+
+func TestExperimentParticipantCap(t *testing.T) {
+	manager := NewExperimentManager()
+	manager.RegisterExperiment(Experiment{
+		Key:             "experiment_key",
+		Alternatives:    Alternatives{{Name: "control"}, {Name: "variant"}},
+		MaxParticipants: 5,
+	})
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		started  []*httptest.ResponseRecorder
+		excluded int
+	)
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			response, err := manager.StartExperiment("experiment_key", w, r)
+			if err != nil {
+				t.Errorf("expected not to error but got: %v", err)
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if response.DidStart {
+				started = append(started, w)
+				return
+			}
+			excluded++
+			if response.Excluded != ExcludedCapReached || response.Alternative != "control" {
+				t.Errorf("expected capped participant to get control but got: %+v", response)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(started) != 5 || excluded != 45 {
+		t.Fatalf("expected 5 participants and 45 exclusions but got %d and %d", len(started), excluded)
+	}
+
+	firstParticipant := started[0]
+	response, err := manager.StartExperiment("experiment_key", httptest.NewRecorder(), newRequestFromResponse(firstParticipant))
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if !response.DidStart || response.DidStartFirstTime {
+		t.Errorf("expected existing participant to keep the experiment but got: %+v", response)
+	}
+
+	_, err = manager.FinishExperiment("experiment_key", httptest.NewRecorder(), newRequestFromResponse(firstParticipant))
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+
+	results, err := manager.GetResults("experiment_key")
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if results.Participants != 5 || results.Completions != 1 {
+		t.Errorf("expected 5 participants and 1 completion but got %d and %d", results.Participants, results.Completions)
+	}
+}
+
+func TestSQLTrackingStore(t *testing.T) {
+	connector := &countsConnector{counters: make(map[[2]string]int64)}
+
+	// two instances of the application share the database
+	var managers []*ExperimentManager
+	for range 2 {
+		store := NewSQLTrackingStore(sql.OpenDB(connector))
+		if err := store.CreateTables(); err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+
+		manager := NewExperimentManager()
+		manager.TrackingStore = store
+		manager.RegisterExperiment(Experiment{
+			Key:             "experiment_key",
+			Alternatives:    Alternatives{{Name: "control"}, {Name: "variant"}},
+			MaxParticipants: 5,
+		})
+		managers = append(managers, manager)
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		started []*httptest.ResponseRecorder
+	)
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			response, err := managers[i%2].StartExperiment("experiment_key", w, r)
+			if err != nil {
+				t.Errorf("expected not to error but got: %v", err)
+				return
+			}
+
+			if response.DidStart {
+				mu.Lock()
+				started = append(started, w)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(started) != 5 {
+		t.Fatalf("expected 5 participants across the instances but got %d", len(started))
+	}
+
+	_, err := managers[1].FinishExperiment("experiment_key", httptest.NewRecorder(), newRequestFromResponse(started[0]))
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	err = managers[0].TrackingStore.AddValue("experiment_key", "variant", "order", 42.5)
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+
+	results, err := managers[0].GetResults("experiment_key")
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if results.Participants != 5 || results.Completions != 1 {
+		t.Errorf("expected 5 participants and 1 completion but got %d and %d", results.Participants, results.Completions)
+	}
+
+	exclusions, err := managers[1].TrackingStore.Exclusions("experiment_key")
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if exclusions[ExcludedCapReached] != 45 {
+		t.Errorf("expected 45 capped participants but got: %v", exclusions)
+	}
+
+	values, err := managers[1].TrackingStore.Values("experiment_key", "order")
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if len(values) != 1 || !slices.Equal(values["variant"], []float64{42.5}) {
+		t.Errorf("expected the value to be shared but got: %v", values)
+	}
+
+	counts, err := managers[0].TrackingStore.Counts("experiment_key")
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if counts["control"].Participants+counts["variant"].Participants != 5 {
+		t.Errorf("expected 5 participants across the alternatives but got: %v", counts)
+	}
+
+	alternative := "control"
+	if counts[alternative].Participants == 0 {
+		alternative = "variant"
+	}
+	if err := managers[0].TrackingStore.RemoveParticipant("experiment_key", alternative); err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	added, err := managers[1].TrackingStore.AddParticipant("experiment_key", "variant", 5)
+	if err != nil || !added {
+		t.Errorf("expected the removed participant to free a slot but got: %t, %v", added, err)
+	}
+}
+
+func TestBotExclusion(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		header    string
+		disabled  bool
+		wantBot   bool
+	}{
+		{
+			name:      "Browser",
+			userAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0",
+		},
+		{
+			name:      "Known crawler",
+			userAgent: "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			wantBot:   true,
+		},
+		{
+			name:      "Crawler with its url",
+			userAgent: "Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm) Chrome/116.0.1938.76 Safari/537.36",
+			wantBot:   true,
+		},
+		{
+			name:      "Device named like a bot",
+			userAgent: "Mozilla/5.0 (Linux; Android 10; CUBOT X30) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+		},
+		{
+			name:      "Detection is disabled by default",
+			userAgent: "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			disabled:  true,
+		},
+		{
+			name:      "Custom predicate",
+			userAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0",
+			header:    "1",
+			wantBot:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := NewExperimentManager()
+			detector := NewBotDetector()
+			detector.Predicate = func(r *http.Request) bool {
+				return r.Header.Get("X-Synthetic-Monitoring") == "1"
+			}
+			if !tt.disabled {
+				manager.BotDetector = detector
+			}
+			manager.RegisterExperiment(Experiment{
+				Key:          "experiment_key",
+				Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}},
+			})
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("User-Agent", tt.userAgent)
+			if len(tt.header) > 0 {
+				r.Header.Set("X-Synthetic-Monitoring", tt.header)
+			}
+
+			response, err := manager.StartExperiment("experiment_key", w, r)
+			if err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+
+			results, err := manager.GetResults("experiment_key")
+			if err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+
+			if !tt.wantBot {
+				if !response.DidStart || results.Participants != 1 {
+					t.Errorf("expected participant to be enrolled but got: %+v", response)
+				}
+				return
+			}
+
+			if response.DidStart || response.Excluded != ExcludedBot || response.Alternative != "control" {
+				t.Errorf("expected bot to be excluded but got: %+v", response)
+			}
+			if len(w.Result().Cookies()) != 0 {
+				t.Error("expected no cookies to be written")
+			}
+			if results.Participants != 0 || results.Exclusions[ExcludedBot] != 1 {
+				t.Errorf("expected only one bot exclusion to be tracked but got: %+v", results)
+			}
+		})
+	}
+}
+
+func TestFlags(t *testing.T) {
+	manager := NewExperimentManager()
+	manager.RegisterFlag(Flag{
+		Key:     "new_checkout",
+		Rollout: 30,
+		Allow:   []string{"qa_user"},
+		Deny:    []string{"vip_user"},
+	})
+	manager.RegisterFlag(Flag{
+		Key:      "button_color",
+		Rollout:  100,
+		Variants: Alternatives{{Name: "red"}, {Name: "blue", Weight: 3}},
+	})
+
+	t.Run("percentage rollout", func(t *testing.T) {
+		enabled := 0
+		for i := range 1000 {
+			ctx := WithSubject(context.Background(), fmt.Sprintf("user_%d", i))
+			if manager.IsEnabled(ctx, "new_checkout") {
+				enabled++
+			}
+			// evaluation must be stable for the same subject
+			if manager.IsEnabled(ctx, "new_checkout") != manager.IsEnabled(ctx, "new_checkout") {
+				t.Fatal("expected flag evaluation to be stable")
+			}
+		}
+		if enabled < 250 || enabled > 350 {
+			t.Errorf("expected around 300 subjects to have the flag enabled but got: %d", enabled)
+		}
+	})
+
+	t.Run("allow and deny lists", func(t *testing.T) {
+		if !manager.IsEnabled(WithSubject(context.Background(), "qa_user"), "new_checkout") {
+			t.Error("expected flag to be enabled for allowed subject")
+		}
+		if manager.IsEnabled(WithSubject(context.Background(), "vip_user"), "new_checkout") {
+			t.Error("expected flag to be disabled for denied subject")
+		}
+		if manager.IsEnabled(context.Background(), "new_checkout") {
+			t.Error("expected partially rolled out flag to be disabled without a subject")
+		}
+	})
+
+	t.Run("multivariate flag", func(t *testing.T) {
+		counts := make(map[string]int)
+		for i := range 1000 {
+			variant, enabled := manager.FlagVariant(WithSubject(context.Background(), fmt.Sprintf("user_%d", i)), "button_color")
+			if !enabled {
+				t.Fatal("expected fully rolled out flag to be enabled")
+			}
+			counts[variant]++
+		}
+		if counts["blue"] < 650 || counts["blue"] > 850 {
+			t.Errorf("expected around 750 subjects to get blue but got: %d", counts["blue"])
+		}
+	})
+
+	t.Run("kill switch", func(t *testing.T) {
+		ctx := WithSubject(context.Background(), "qa_user")
+		if err := manager.SetFlagKilled("new_checkout", true); err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if manager.IsEnabled(ctx, "new_checkout") {
+			t.Error("expected killed flag to be disabled")
+		}
+		if err := manager.SetFlagKilled("new_checkout", false); err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if !manager.IsEnabled(ctx, "new_checkout") {
+			t.Error("expected revived flag to be enabled")
+		}
+		if err := manager.SetFlagKilled("unknown", true); err == nil {
+			t.Error("expected to error but did not")
+		}
+	})
+
+	t.Run("subject from the persistence store", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		ctx, err := manager.RequestContext(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+
+		ctx2, err := manager.RequestContext(httptest.NewRecorder(), newRequestFromResponse(w))
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+
+		first, _ := SubjectFromContext(ctx)
+		second, _ := SubjectFromContext(ctx2)
+		if len(first) == 0 || first != second {
+			t.Errorf("expected the same subject across requests but got %q and %q", first, second)
+		}
+	})
+}
+
+func TestTypedExperiment(t *testing.T) {
+	type buttonConfig struct {
+		Color string `json:"color"`
+		Price int    `json:"price"`
+	}
+
+	t.Run("payload is served with the alternative", func(t *testing.T) {
+		manager := NewExperimentManager()
+		RegisterTypedExperiment[buttonConfig](manager, Experiment{
+			Key: "experiment_key",
+			Alternatives: Alternatives{
+				{Name: "control", Payload: json.RawMessage(`{"color": "blue", "price": 10}`)},
+				{Name: "variant", Payload: json.RawMessage(`{"color": "red", "price": 12}`)},
+			},
+		})
+
+		config, response, err := StartTypedExperiment[buttonConfig](manager, "experiment_key", httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+
+		want := map[string]buttonConfig{
+			"control": {Color: "blue", Price: 10},
+			"variant": {Color: "red", Price: 12},
+		}
+		if config != want[response.Alternative] {
+			t.Errorf("expected payload to be %+v but got: %+v", want[response.Alternative], config)
+		}
+	})
+
+	invalidPayloads := map[string]json.RawMessage{
+		"Missing payload": nil,
+		"Unknown field":   json.RawMessage(`{"colour": "red"}`),
+		"Wrong type":      json.RawMessage(`{"price": "12"}`),
+		"Invalid JSON":    json.RawMessage(`{"color": `),
+	}
+	for name, payload := range invalidPayloads {
+		t.Run(name, func(t *testing.T) {
+			manager := NewExperimentManager()
+			assertPanic(t, func() {
+				RegisterTypedExperiment[buttonConfig](manager, Experiment{
+					Key: "experiment_key",
+					Alternatives: Alternatives{
+						{Name: "control", Payload: json.RawMessage(`{"color": "blue"}`)},
+						{Name: "variant", Payload: payload},
+					},
+				})
+			})
+		})
+	}
+
+	type pageConfig struct {
+		Headline string       `json:"headline"`
+		Button   buttonConfig `json:"button"`
+	}
+	factors := func(button json.RawMessage) []Factor {
+		return []Factor{
+			{Key: "headline", Alternatives: Alternatives{{Name: "short", Payload: json.RawMessage(`"Buy"`)}, {Name: "long", Payload: json.RawMessage(`"Buy it now"`)}}},
+			{Key: "button", Alternatives: Alternatives{{Name: "blue", Payload: json.RawMessage(`{"color": "blue"}`)}, {Name: "red", Payload: button}}},
+		}
+	}
+
+	t.Run("payloads of the combinations", func(t *testing.T) {
+		manager := NewExperimentManager()
+		RegisterTypedExperiment[pageConfig](manager, Experiment{Key: "experiment_key", Factors: factors(json.RawMessage(`{"color": "red"}`))})
+
+		config, response, err := StartTypedExperiment[pageConfig](manager, "experiment_key", httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if !strings.Contains(response.Alternative, config.Button.Color) || len(config.Headline) == 0 {
+			t.Errorf("expected the payload of %s but got: %+v", response.Alternative, config)
+		}
+
+		assertPanic(t, func() {
+			RegisterTypedExperiment[pageConfig](NewExperimentManager(), Experiment{Key: "experiment_key", Factors: factors(json.RawMessage(`{"colour": "red"}`))})
+		})
+	})
+
+	t.Run("payloads of the stored experiments", func(t *testing.T) {
+		store := NewFileExperimentStore(filepath.Join(t.TempDir(), "experiments.json"))
+		manager := NewExperimentManager()
+		manager.ExperimentStore = store
+		RegisterTypedExperiment[pageConfig](manager, Experiment{Key: "experiment_key", Factors: factors(json.RawMessage(`{"color": "red"}`))})
+
+		store.Set("experiment_key", Experiment{Key: "experiment_key", Factors: factors(json.RawMessage(`{"price": "12"}`))})
+		err := manager.ReloadExperiments()
+		var invalid *InvalidExperimentError
+		if !errors.As(err, &invalid) {
+			t.Fatalf("expected an InvalidExperimentError but got: %v", err)
+		}
+
+		store.Set("experiment_key", Experiment{Key: "experiment_key", Factors: factors(json.RawMessage(`{"color": "green"}`))})
+		if err := manager.ReloadExperiments(); err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+	})
+}
+
+func TestContextAPI(t *testing.T) {
+	manager := NewExperimentManager()
+	manager.RegisterExperiment(Experiment{
+		Key:          "experiment_key",
+		Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}},
+	})
+	ctx := context.Background()
+
+	_, err := manager.Start(ctx, "", "experiment_key")
+	if !errors.Is(err, ErrMissingSubject) {
+		t.Errorf("expected error to be %v but got: %v", ErrMissingSubject, err)
+	}
+
+	first, err := manager.Start(ctx, "user_1", "experiment_key")
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if !first.DidStart || !first.DidStartFirstTime {
+		t.Errorf("expected experiment to start for the first time but got: %+v", first)
+	}
+
+	second, err := manager.Start(ctx, "user_1", "experiment_key")
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if second.DidStartFirstTime || second.Alternative != first.Alternative {
+		t.Errorf("expected the same assignment to be returned but got: %+v", second)
+	}
+
+	finish, err := manager.Finish(ctx, "user_2", "experiment_key")
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if finish.DidFinish {
+		t.Error("expected subject that did not start not to finish")
+	}
+
+	for _, wantFirstTime := range []bool{true, false} {
+		finish, err = manager.Finish(ctx, "user_1", "experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if !finish.DidFinish || finish.DidFinishFirstTime != wantFirstTime || finish.Alternative != first.Alternative {
+			t.Errorf("expected subject to finish with DidFinishFirstTime %t but got: %+v", wantFirstTime, finish)
+		}
+	}
+
+	t.Run("assignments expire when the subject is not seen", func(t *testing.T) {
+		now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+		store := NewMemoryAssignmentStore()
+		store.Now = func() time.Time { return now }
+
+		if err := store.PersistExperiment(ctx, "user_1", "experiment_key", "variant", 0); err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		now = now.Add(DefaultAssignmentTtl / 2)
+		store.RefreshTtl(ctx, "user_1")
+
+		now = now.Add(DefaultAssignmentTtl / 2)
+		if exists, _, _, _ := store.ExperimentExists(ctx, "user_1", "experiment_key"); !exists {
+			t.Error("expected the refreshed assignment to be kept")
+		}
+
+		now = now.Add(DefaultAssignmentTtl)
+		if exists, _, _, _ := store.ExperimentExists(ctx, "user_1", "experiment_key"); exists {
+			t.Error("expected the assignment to expire")
+		}
+	})
+
+	t.Run("persistence store adapted to the context based api", func(t *testing.T) {
+		manager := NewExperimentManager()
+		manager.AssignmentStore = NewHTTPAssignmentStore(manager.PersistenceStore)
+		manager.RegisterExperiment(Experiment{
+			Key:          "experiment_key",
+			Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}},
+		})
+
+		if _, err := manager.Start(ctx, "user_1", "experiment_key"); !errors.Is(err, ErrMissingHTTP) {
+			t.Errorf("expected error to be %v but got: %v", ErrMissingHTTP, err)
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		response, err := manager.Start(WithHTTP(ctx, w, r), "user_1", "experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if getExperimentCookieValue(t, w, cookieName)["experiment_key"] != response.Alternative {
+			t.Errorf("expected the assignment to be kept in the cookie")
+		}
+	})
+}
+
+func TestAssignmentsPropagation(t *testing.T) {
+	t.Run("encoding", func(t *testing.T) {
+		assignments := Assignments{
+			"Test key":      "control",
+			"checkout=flow": "variant,b;c",
+		}
+
+		parsed, err := ParseAssignments(assignments.Encode())
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if !maps.Equal(parsed, assignments) {
+			t.Errorf("expected assignments to be %v but got: %v", assignments, parsed)
+		}
+
+		parsed, err = ParseAssignments(" a=b ;property, , c=d")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if !maps.Equal(parsed, Assignments{"a": "b", "c": "d"}) {
+			t.Errorf("expected baggage properties and empty members to be ignored but got: %v", parsed)
+		}
+
+		if _, err := ParseAssignments("a"); err == nil {
+			t.Error("expected to error but did not")
+		}
+	})
+
+	t.Run("downstream service honors the upstream assignment", func(t *testing.T) {
+		backend := NewExperimentManager()
+		backend.RegisterExperiment(Experiment{
+			Key:          "experiment_key",
+			Alternatives: Alternatives{{Name: "control"}, {Name: "variant", Weight: 1000000}},
+		})
+
+		var response *StartExperimentResponse
+		var finish *FinishExperimentResponse
+		server := httptest.NewServer(ExtractAssignments(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			subject, _ := SubjectFromContext(r.Context())
+
+			var err error
+			response, err = backend.Start(r.Context(), subject, "experiment_key")
+			if err != nil {
+				t.Errorf("expected not to error but got: %v", err)
+			}
+			finish, err = backend.Finish(r.Context(), subject, "experiment_key")
+			if err != nil {
+				t.Errorf("expected not to error but got: %v", err)
+			}
+		})))
+		defer server.Close()
+
+		ctx := WithSubject(context.Background(), "user_1")
+		ctx = WithAssignments(ctx, Assignments{"experiment_key": "control"})
+		r, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		client := &http.Client{Transport: NewTransport(nil)}
+		res, err := client.Do(r)
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		res.Body.Close()
+
+		if response.Alternative != "control" || !response.Propagated {
+			t.Errorf("expected upstream alternative to be honored but got: %+v", response)
+		}
+		if finish == nil || finish.Alternative != "control" || !finish.Propagated || finish.DidFinish {
+			t.Errorf("expected the finish to report the upstream alternative but got: %+v", finish)
+		}
+
+		results, err := backend.GetResults("experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if results.Participants != 0 {
+			t.Errorf("expected propagated participant not to be tracked again but got: %d", results.Participants)
+		}
+	})
+
+	t.Run("assignments of another subject are ignored", func(t *testing.T) {
+		backend := NewExperimentManager()
+		backend.RegisterExperiment(Experiment{
+			Key:          "experiment_key",
+			Alternatives: Alternatives{{Name: "control"}, {Name: "variant", Weight: 1000000}},
+		})
+
+		ctx := WithSubject(context.Background(), "alice")
+		ctx = WithAssignments(ctx, Assignments{"experiment_key": "control"})
+
+		response, err := backend.Start(ctx, "bob", "experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if response.Propagated || response.Alternative != "variant" || !response.DidStartFirstTime {
+			t.Errorf("expected bob to be enrolled independently but got: %+v", response)
+		}
+
+		response, err = backend.Start(ctx, "alice", "experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if !response.Propagated || response.Alternative != "control" {
+			t.Errorf("expected the assignment of alice to be honored but got: %+v", response)
+		}
+	})
+
+	t.Run("only signed assignments are verified", func(t *testing.T) {
+		secret := []byte("secret")
+		backend := NewExperimentManager()
+		backend.RegisterExperiment(Experiment{
+			Key:          "experiment_key",
+			Alternatives: Alternatives{{Name: "control"}, {Name: "variant", Weight: 1000000}},
+		})
+
+		var response *StartExperimentResponse
+		server := httptest.NewServer(VerifyAssignments(secret, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var err error
+			response, err = backend.Start(r.Context(), "user_1", "experiment_key")
+			if err != nil {
+				t.Errorf("expected not to error but got: %v", err)
+			}
+		})))
+		defer server.Close()
+
+		for _, transport := range []*Transport{{Secret: secret}, {Secret: []byte("other")}, {}} {
+			ctx := WithAssignments(context.Background(), Assignments{"experiment_key": "control"})
+			r, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := (&http.Client{Transport: transport}).Do(r)
+			if err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+			res.Body.Close()
+
+			wantPropagated := string(transport.Secret) == string(secret)
+			if response.Propagated != wantPropagated {
+				t.Errorf("expected Propagated to be %t with secret %q but got: %+v", wantPropagated, transport.Secret, response)
+			}
+		}
+	})
+
+	t.Run("propagated assignments honor the schedule", func(t *testing.T) {
+		backend := NewExperimentManager()
+		backend.Now = func() time.Time { return time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC) }
+		backend.RegisterExperiment(Experiment{
+			Key:          "experiment_key",
+			Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}},
+			StartsAt:     time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC),
+		})
+
+		ctx := WithAssignments(context.Background(), Assignments{"experiment_key": "variant"})
+		response, err := backend.Start(ctx, "user_1", "experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if response.Alternative != "control" || response.Excluded != ExcludedNotStarted || response.DidStart {
+			t.Errorf("expected the experiment that has not started to serve the control but got: %+v", response)
+		}
+	})
+
+	t.Run("propagated assignments of paused experiments are kept", func(t *testing.T) {
+		backend := NewExperimentManager()
+		backend.RegisterExperiment(Experiment{
+			Key:          "experiment_key",
+			Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}},
+			Paused:       true,
+		})
+
+		ctx := WithAssignments(context.Background(), Assignments{"experiment_key": "variant"})
+		response, err := backend.Start(ctx, "user_1", "experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if response.Alternative != "variant" || !response.Propagated || !response.DidStart {
+			t.Errorf("expected the upstream participant to keep the variant but got: %+v", response)
+		}
+	})
+}
+
+func TestLogging(t *testing.T) {
+	newManager := func(buf *bytes.Buffer) *ExperimentManager {
+		manager := NewExperimentManager()
+		manager.Logger = slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		manager.RegisterExperiment(Experiment{
+			Key:          "experiment_key",
+			Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}},
+		})
+
+		return manager
+	}
+
+	t.Run("subjects are not logged by default", func(t *testing.T) {
+		var buf bytes.Buffer
+		manager := newManager(&buf)
+
+		_, err := manager.Start(context.Background(), "secret_user", "experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if !strings.Contains(buf.String(), `"swole.experiment":"experiment_key"`) {
+			t.Errorf("expected assignment to be logged but got: %s", buf.String())
+		}
+		if strings.Contains(buf.String(), "secret_user") {
+			t.Errorf("expected subject not to be logged but got: %s", buf.String())
+		}
+
+		buf.Reset()
+		manager.LogSubjects = true
+		_, err = manager.Finish(context.Background(), "secret_user", "experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if !strings.Contains(buf.String(), "secret_user") {
+			t.Errorf("expected subject to be logged but got: %s", buf.String())
+		}
+	})
+
+	t.Run("malformed cookies are logged as warnings", func(t *testing.T) {
+		var buf bytes.Buffer
+		manager := newManager(&buf)
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(&http.Cookie{Name: cookieName, Value: "not-json"})
+		_, err := manager.StartExperiment("experiment_key", httptest.NewRecorder(), r)
+		if err == nil {
+			t.Fatal("expected to error but did not")
+		}
+
+		var record map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record["level"] != "WARN" || record[AttributeErrorType] != "decode" {
+			t.Errorf("expected a decode warning but got: %v", record)
+		}
+	})
+}
+
+func TestFailurePolicy(t *testing.T) {
+	newCorruptedRequest := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(&http.Cookie{Name: cookieName, Value: "not-json"})
+		return r
+	}
+
+	tests := []struct {
+		name          string
+		policy        FailurePolicy
+		wantErr       bool
+		wantStart     bool
+		wantFailure   FailurePolicy
+		wantNewCookie bool
+	}{
+		{
+			name:    "Fail closed",
+			wantErr: true,
+		},
+		{
+			name:        "Fail open",
+			policy:      FailOpen,
+			wantFailure: FailOpen,
+		},
+		{
+			name:          "Reset and reassign",
+			policy:        ResetAndReassign,
+			wantStart:     true,
+			wantFailure:   ResetAndReassign,
+			wantNewCookie: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events bytes.Buffer
+			manager := NewExperimentManager()
+			manager.FailurePolicy = tt.policy
+			manager.EventSink = NewJSONLinesEventSink(&events)
+			manager.RegisterExperiment(Experiment{
+				Key:          "experiment_key",
+				Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}},
+			})
+
+			w := httptest.NewRecorder()
+			response, err := manager.StartExperiment("experiment_key", w, newCorruptedRequest())
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected to error but did not")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+
+			if response.DidStart != tt.wantStart || response.Failure != tt.wantFailure || response.FailureError == nil {
+				t.Errorf("expected DidStart %t with failure %s but got: %+v", tt.wantStart, tt.wantFailure, response)
+			}
+			if !tt.wantStart && response.Alternative != "control" {
+				t.Errorf("expected alternative to be control but got: %s", response.Alternative)
+			}
+			if !strings.Contains(events.String(), `"type":"failure"`) || !strings.Contains(events.String(), `"failure":"`+string(tt.wantFailure)+`"`) {
+				t.Errorf("expected the %s decision to be emitted but got: %s", tt.wantFailure, events.String())
+			}
+
+			if tt.wantNewCookie {
+				cookieValue := getExperimentCookieValue(t, w, cookieName)
+				if cookieValue["experiment_key"] != response.Alternative || len(cookieValue) != 2 {
+					t.Errorf("expected the cookie to hold only the new assignment but got: %v", cookieValue)
+				}
+			}
+		})
+	}
+
+	t.Run("participants that could not be persisted are not counted", func(t *testing.T) {
+		manager := NewExperimentManager()
+		manager.FailurePolicy = FailOpen
+		manager.AssignmentStore = failingAssignmentStore{NewMemoryAssignmentStore()}
+		manager.RegisterExperiment(Experiment{
+			Key:             "experiment_key",
+			Alternatives:    Alternatives{{Name: "control"}, {Name: "variant"}},
+			MaxParticipants: 1,
+		})
+
+		response, err := manager.Start(context.Background(), "user", "experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if response.Failure != FailOpen {
+			t.Errorf("expected failure to be %s but got: %s", FailOpen, response.Failure)
+		}
+
+		results, err := manager.GetResults("experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if results.Participants != 0 {
+			t.Errorf("expected the participant not to be counted but got: %d", results.Participants)
+		}
+	})
+
+	t.Run("assignments that cannot be extended are served without a reset", func(t *testing.T) {
+		store := failingAssignmentStore{NewMemoryAssignmentStore()}
+		store.MemoryAssignmentStore.PersistExperiment(context.Background(), "user", "experiment_key", "variant", 0)
+
+		manager := NewExperimentManager()
+		manager.FailurePolicy = ResetAndReassign
+		manager.AssignmentStore = store
+		manager.RegisterExperiment(Experiment{
+			Key:          "experiment_key",
+			Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}},
+		})
+
+		response, err := manager.Start(context.Background(), "user", "experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if !response.DidStart || response.Alternative != "variant" || response.Failure != FailOpen {
+			t.Errorf("expected the assignment to be served failing open but got: %+v", response)
+		}
+	})
+}
+
+// failingAssignmentStore fails to persist any assignment and to extend their lifetime
+type failingAssignmentStore struct {
+	*MemoryAssignmentStore
+}
+
+func (s failingAssignmentStore) PersistExperiment(ctx context.Context, subject, key, alternative string, version int) error {
+	return errors.New("unavailable")
+}
+
+func (s failingAssignmentStore) RefreshTtl(ctx context.Context, subject string) error {
+	return errors.New("unavailable")
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	manager := NewExperimentManager()
+	manager.BotDetector = NewBotDetector()
+	manager.RegisterExperiment(Experiment{
+		Key:          "experiment_key",
+		Alternatives: Alternatives{{Name: "control"}, {Name: "variant", Weight: 1000000}},
+	})
+
+	w := httptest.NewRecorder()
+	_, err := manager.StartExperiment("experiment_key", w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	_, err = manager.FinishExperiment("experiment_key", httptest.NewRecorder(), newRequestFromResponse(w))
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+
+	bot := httptest.NewRequest(http.MethodGet, "/", nil)
+	bot.Header.Set("User-Agent", "Googlebot/2.1")
+	_, err = manager.StartExperiment("experiment_key", httptest.NewRecorder(), bot)
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+
+	corrupted := httptest.NewRequest(http.MethodGet, "/", nil)
+	corrupted.AddCookie(&http.Cookie{Name: cookieName, Value: "not-json"})
+	manager.StartExperiment("experiment_key", httptest.NewRecorder(), corrupted)
+
+	metrics := httptest.NewRecorder()
+	manager.PrometheusHandler().ServeHTTP(metrics, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := metrics.Body.String()
+	for _, line := range []string{
+		`swole_participants_total{experiment="experiment_key",alternative="control"} 0`,
+		`swole_participants_total{experiment="experiment_key",alternative="variant"} 1`,
+		`swole_conversions_total{experiment="experiment_key",alternative="variant"} 1`,
+		`swole_exclusions_total{experiment="experiment_key",reason="bot"} 1`,
+		`swole_errors_total{operation="start",type="decode"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected metrics to contain %s but got:\n%s", line, body)
+		}
+	}
+}
+
+func TestExportEvents(t *testing.T) {
+	var events bytes.Buffer
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	manager := NewExperimentManager()
+	manager.EventSink = NewJSONLinesEventSink(&events)
+	manager.Now = func() time.Time { return now }
+	manager.RegisterExperiment(Experiment{
+		Key:          "experiment_key",
+		Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}},
+	})
+
+	ctx := context.Background()
+	for i := range 10 {
+		now = now.Add(time.Hour)
+		subject := fmt.Sprintf("user_%d", i)
+		if _, err := manager.Start(ctx, subject, "experiment_key"); err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		// starting again is not a new exposure
+		if _, err := manager.Start(ctx, subject, "experiment_key"); err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if i%2 == 0 {
+			if _, err := manager.Finish(ctx, subject, "experiment_key"); err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	exported, err := ExportEvents(bytes.NewReader(events.Bytes()), EventFilter{
+		Experiment: "experiment_key",
+		Types:      []EventType{EventExposure},
+		From:       time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+		To:         time.Date(2026, 3, 1, 15, 0, 0, 0, time.UTC),
+	}, NewCSVEventWriter(&out))
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+
+	if exported != 3 {
+		t.Errorf("expected 3 events to be exported but got: %d", exported)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 || lines[0] != "type,time,experiment,alternative,subject,goal,value,failure,holdout" {
+		t.Errorf("expected a header and 3 rows but got:\n%s", out.String())
+	}
+	if !strings.HasPrefix(lines[1], "exposure,2026-03-01T12:00:00Z,experiment_key,") || !strings.HasSuffix(lines[1], ",user_2,,,,") {
+		t.Errorf("unexpected row: %s", lines[1])
+	}
+
+	goals, err := ExportEvents(bytes.NewReader(events.Bytes()), EventFilter{Types: []EventType{EventGoal}}, NewJSONLinesEventWriter(io.Discard))
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if goals != 5 {
+		t.Errorf("expected 5 goal events but got: %d", goals)
+	}
+
+	results, err := ResultsFromEvents(bytes.NewReader(events.Bytes()), Experiment{
+		Key:          "experiment_key",
+		Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}},
+	})
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if results.Participants != 10 || results.Completions != 5 {
+		t.Errorf("expected 10 participants and 5 completions but got: %d and %d", results.Participants, results.Completions)
+	}
+
+	if _, err := NewEventWriter(io.Discard, "parquet"); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("expected error to be %v but got: %v", ErrUnsupportedFormat, err)
+	}
+}
+
+func TestExperimentStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "experiments.json")
+	store := NewFileExperimentStore(path)
+
+	experiment := Experiment{
+		Key:          "experiment_key",
+		Alternatives: Alternatives{{Name: "control", Weight: 1}, {Name: "variant", Weight: 3, Payload: json.RawMessage(`{"color":"red"}`)}},
+		Version:      1,
+		EndsAt:       time.Date(2026, 3, 15, 9, 0, 0, 0, time.UTC),
+	}
+	if err := store.Set(experiment.Key, experiment); err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+
+	got, found, err := NewFileExperimentStore(path).Get("experiment_key")
+	if err != nil || !found {
+		t.Fatalf("expected the experiment to be found but got: %t, %v", found, err)
+	}
+	var payload bytes.Buffer
+	json.Compact(&payload, got.Alternatives[1].Payload)
+	if got.Alternatives[1].Weight != 3 || payload.String() != `{"color":"red"}` || !got.EndsAt.Equal(experiment.EndsAt) {
+		t.Errorf("expected the experiment to round trip but got: %+v", got)
+	}
+
+	manager := NewExperimentManager()
+	manager.ExperimentStore = store
+	manager.Now = func() time.Time { return time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC) }
+	manager.RegisterExperiment(Experiment{
+		Key:          "code_key",
+		Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}},
+	})
+
+	if err := manager.ReloadExperiments(); err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if len(manager.GetRegisterExperiments()) != 2 {
+		t.Errorf("expected the stored and the registered experiments but got: %v", manager.GetRegisterExperiments())
+	}
+
+	t.Run("Paused experiments do not enroll", func(t *testing.T) {
+		ctx := context.Background()
+		enrolled, err := manager.Start(ctx, "user_0", "experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+
+		experiment.Paused = true
+		store.Set(experiment.Key, experiment)
+		if err := manager.ReloadExperiments(); err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+
+		response, err := manager.Start(ctx, "user_1", "experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if response.DidStart || response.Excluded != ExcludedPaused || response.Alternative != "control" {
+			t.Errorf("expected to be excluded with the control but got: %+v", response)
+		}
+
+		// the participants that are already enrolled keep their alternative
+		response, err = manager.Start(ctx, "user_0", "experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if !response.DidStart || len(response.Excluded) > 0 || response.Alternative != enrolled.Alternative {
+			t.Errorf("expected to keep %s but got: %+v", enrolled.Alternative, response)
+		}
+		finish, err := manager.Finish(ctx, "user_0", "experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if !finish.DidFinish || finish.Alternative != enrolled.Alternative {
+			t.Errorf("expected to finish %s but got: %+v", enrolled.Alternative, finish)
+		}
+	})
+
+	t.Run("Overrides keep the registered allocator", func(t *testing.T) {
+		manager := NewExperimentManager()
+		manager.ExperimentStore = store
+		manager.RegisterExperiment(Experiment{
+			Key:          "allocated_key",
+			Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}},
+			Allocator:    allocatorFunc(func(ctx context.Context, allocation AllocationContext) string { return "variant" }),
+		})
+
+		store.Set("allocated_key", Experiment{Key: "allocated_key", Alternatives: Alternatives{{Name: "control", Weight: 1000}, {Name: "variant"}}})
+		defer store.Delete("allocated_key")
+		if err := manager.ReloadExperiments(); err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+
+		response, err := manager.Start(context.Background(), "user_0", "allocated_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if response.Alternative != "variant" {
+			t.Errorf("expected the registered allocator to choose variant but got: %+v", response)
+		}
+	})
+
+	t.Run("Invalid experiments keep the previous ones", func(t *testing.T) {
+		store.Set("invalid_key", Experiment{Key: "invalid_key", Alternatives: Alternatives{{Name: "control"}}})
+
+		err := manager.ReloadExperiments()
+		var invalid *InvalidExperimentError
+		if !errors.As(err, &invalid) {
+			t.Fatalf("expected an InvalidExperimentError but got: %v", err)
+		}
+		if _, found := manager.getExperiment("experiment_key"); !found {
+			t.Errorf("expected the previous experiments to be kept")
+		}
+	})
+
+	store.Delete("invalid_key")
+	store.Delete("experiment_key")
+	if err := manager.ReloadExperiments(); err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if _, found := manager.getExperiment("experiment_key"); found {
+		t.Errorf("expected the deleted experiment to be unloaded")
+	}
+	if _, found := manager.getExperiment("code_key"); !found {
+		t.Errorf("expected the registered experiment to be kept")
+	}
+
+	if err := NewExperimentManager().WatchExperiments(context.Background(), time.Second); !errors.Is(err, ErrNoExperimentStore) {
+		t.Errorf("expected error to be %v but got: %v", ErrNoExperimentStore, err)
+	}
+}
+
+func TestSQLExperimentStore(t *testing.T) {
+	db := sql.OpenDB(&tableConnector{rows: make(map[string]string)})
+	defer db.Close()
+
+	store := NewSQLExperimentStore(db)
+	if err := store.CreateTable(); err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+
+	for _, key := range []string{"second_key", "first_key", "first_key"} {
+		err := store.Set(key, Experiment{Alternatives: Alternatives{{Name: "control"}, {Name: "variant", Weight: 3}}})
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+	}
+
+	got, found, err := store.Get("first_key")
+	if err != nil || !found {
+		t.Fatalf("expected the experiment to be found but got: %t, %v", found, err)
+	}
+	if got.Key != "first_key" || got.Alternatives[1].Weight != 3 {
+		t.Errorf("expected the experiment to round trip but got: %+v", got)
+	}
+
+	if err := store.Delete("second_key"); err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	experiments, err := store.List()
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if len(experiments) != 1 || experiments[0].Key != "first_key" {
+		t.Errorf("expected only first_key to be listed but got: %+v", experiments)
+	}
+
+	if _, found, _ := store.Get("second_key"); found {
+		t.Error("expected the deleted experiment not to be found")
+	}
+}
+
+// tableConnector is a database/sql driver holding the rows of a single experiments table, it
+// understands only the statements of the SQLExperimentStore
+type tableConnector struct {
+	mu   sync.Mutex
+	rows map[string]string
+}
+
+func (c *tableConnector) Connect(ctx context.Context) (driver.Conn, error) { return c, nil }
+func (c *tableConnector) Driver() driver.Driver                            { return nil }
+func (c *tableConnector) Close() error                                     { return nil }
+func (c *tableConnector) Begin() (driver.Tx, error)                        { return c, nil }
+func (c *tableConnector) Commit() error                                    { return nil }
+func (c *tableConnector) Rollback() error                                  { return nil }
+
+func (c *tableConnector) Prepare(query string) (driver.Stmt, error) {
+	return &tableStmt{connector: c, query: query}, nil
+}
+
+type tableStmt struct {
+	connector *tableConnector
+	query     string
+}
+
+func (s *tableStmt) Close() error  { return nil }
+func (s *tableStmt) NumInput() int { return strings.Count(s.query, "?") }
+
+func (s *tableStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.connector.mu.Lock()
+	defer s.connector.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(s.query, "CREATE TABLE"):
+	case strings.HasPrefix(s.query, "DELETE"):
+		delete(s.connector.rows, args[0].(string))
+	case strings.HasPrefix(s.query, "INSERT"):
+		s.connector.rows[args[0].(string)] = args[1].(string)
+	default:
+		return nil, fmt.Errorf("unexpected statement: %s", s.query)
+	}
+
+	return driver.RowsAffected(1), nil
+}
+
+func (s *tableStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.connector.mu.Lock()
+	defer s.connector.mu.Unlock()
+
+	var definitions []string
+	if len(args) > 0 {
+		if definition, found := s.connector.rows[args[0].(string)]; found {
+			definitions = append(definitions, definition)
+		}
+	} else {
+		for _, key := range slices.Sorted(maps.Keys(s.connector.rows)) {
+			definitions = append(definitions, s.connector.rows[key])
+		}
+	}
+
+	return &tableRows{definitions: definitions}, nil
+}
+
+type tableRows struct {
+	definitions []string
+}
+
+func (r *tableRows) Columns() []string { return []string{"definition"} }
+func (r *tableRows) Close() error      { return nil }
+
+func (r *tableRows) Next(dest []driver.Value) error {
+	if len(r.definitions) == 0 {
+		return io.EOF
+	}
+	dest[0], r.definitions = r.definitions[0], r.definitions[1:]
+
+	return nil
+}
+
+// countsConnector is a database/sql driver holding the tables of a SQLTrackingStore, it
+// understands only its statements. Every statement runs on its own, like the row locks of a
+// database would have them
+type countsConnector struct {
+	mu       sync.Mutex
+	counters map[[2]string]int64
+	values   [][4]any
+}
+
+func (c *countsConnector) Connect(ctx context.Context) (driver.Conn, error) { return c, nil }
+func (c *countsConnector) Driver() driver.Driver                            { return nil }
+func (c *countsConnector) Close() error                                     { return nil }
+func (c *countsConnector) Begin() (driver.Tx, error) {
+	return nil, errors.New("unexpected transaction")
+}
+
+func (c *countsConnector) Prepare(query string) (driver.Stmt, error) {
+	return &countsStmt{connector: c, query: query}, nil
+}
+
+type countsStmt struct {
+	connector *countsConnector
+	query     string
+}
+
+func (s *countsStmt) Close() error  { return nil }
+func (s *countsStmt) NumInput() int { return strings.Count(s.query, "?") }
+
+func (s *countsStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.connector.mu.Lock()
+	defer s.connector.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(s.query, "CREATE TABLE"):
+	case strings.HasPrefix(s.query, "UPDATE"):
+		counter := [2]string{args[0].(string), args[1].(string)}
+		amount, found := s.connector.counters[counter]
+		switch {
+		case !found:
+			return driver.RowsAffected(0), nil
+		case strings.Contains(s.query, "amount > 0") && amount <= 0:
+			return driver.RowsAffected(0), nil
+		case strings.Contains(s.query, "amount < ?") && amount >= args[2].(int64):
+			return driver.RowsAffected(0), nil
+		case strings.Contains(s.query, "amount + -1"):
+			s.connector.counters[counter]--
+		default:
+			s.connector.counters[counter]++
+		}
+	case strings.HasPrefix(s.query, "INSERT INTO swole_counts"):
+		counter := [2]string{args[0].(string), args[1].(string)}
+		if _, found := s.connector.counters[counter]; found {
+			return nil, errors.New("duplicate key")
+		}
+		s.connector.counters[counter] = 0
+	case strings.HasPrefix(s.query, "INSERT INTO swole_values"):
+		s.connector.values = append(s.connector.values, [4]any{args[0], args[1], args[2], args[3]})
+	default:
+		return nil, fmt.Errorf("unexpected statement: %s", s.query)
+	}
+
+	return driver.RowsAffected(1), nil
+}
+
+func (s *countsStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.connector.mu.Lock()
+	defer s.connector.mu.Unlock()
+
+	rows := &countsRows{}
+	switch {
+	case strings.Contains(s.query, "FROM swole_counts"):
+		for counter, amount := range s.connector.counters {
+			if counter[0] == args[0] {
+				rows.rows = append(rows.rows, []driver.Value{counter[1], amount})
+			}
+		}
+	case strings.Contains(s.query, "FROM swole_values"):
+		for _, value := range s.connector.values {
+			if value[0] == args[0] && value[1] == args[1] {
+				rows.rows = append(rows.rows, []driver.Value{value[2], value[3]})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unexpected query: %s", s.query)
+	}
+
+	return rows, nil
+}
+
+type countsRows struct {
+	rows [][]driver.Value
+}
+
+func (r *countsRows) Columns() []string { return []string{"name", "amount"} }
+func (r *countsRows) Close() error      { return nil }
+
+func (r *countsRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+
+	return nil
+}
+
+func TestCookieValue(t *testing.T) {
+	secret := []byte("secret")
+
+	manager := NewExperimentManager()
+	store := NewCookiePersistenceStore()
+	store.Secret = secret
+	manager.PersistenceStore = store
+	manager.RegisterExperiment(Experiment{
+		Key:          "experiment_key",
+		Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}},
+		Version:      2,
+	})
+
+	w := httptest.NewRecorder()
+	response, err := manager.StartExperiment("experiment_key", w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+
+	value := getExperimentCookie(t, w, "swole").Value
+	assignments, signed, err := DecodeCookieValue(value, secret)
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	want := CookieAssignments{"experiment_key": {Alternative: response.Alternative, Version: 2}}
+	if !signed || !maps.Equal(assignments, want) {
+		t.Errorf("expected signed assignments %v but got: %t, %v", want, signed, assignments)
+	}
+
+	if _, _, err := DecodeCookieValue(value, []byte("other")); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected error to be %v but got: %v", ErrInvalidSignature, err)
+	}
+
+	t.Run("Encoded values are served", func(t *testing.T) {
+		value, err := EncodeCookieValue(CookieAssignments{"experiment_key": {Alternative: "variant", Version: 2}}, secret)
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(&http.Cookie{Name: "swole", Value: value})
+		response, err := manager.StartExperiment("experiment_key", httptest.NewRecorder(), r)
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if response.Alternative != "variant" || response.DidStartFirstTime {
+			t.Errorf("expected the encoded assignment to be served but got: %+v", response)
+		}
+	})
+
+	t.Run("Unsigned values are rejected", func(t *testing.T) {
+		value, err := EncodeCookieValue(CookieAssignments{"experiment_key": {Alternative: "variant", Version: 2}}, nil)
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(&http.Cookie{Name: "swole", Value: value})
+		if _, err := manager.StartExperiment("experiment_key", httptest.NewRecorder(), r); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("expected error to be %v but got: %v", ErrInvalidSignature, err)
+		}
+	})
+
+	t.Run("Unsigned values are accepted during the migration", func(t *testing.T) {
+		store.AcceptUnsigned = true
+		defer func() { store.AcceptUnsigned = false }()
+
+		value, err := EncodeCookieValue(CookieAssignments{"experiment_key": {Alternative: "variant", Version: 2}}, nil)
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(&http.Cookie{Name: "swole", Value: value})
+		response, err := manager.StartExperiment("experiment_key", w, r)
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if response.Alternative != "variant" || response.DidStartFirstTime {
+			t.Errorf("expected the unsigned assignment to be served but got: %+v", response)
+		}
+		if _, signed, err := DecodeCookieValue(getExperimentCookie(t, w, "swole").Value, secret); !signed || err != nil {
+			t.Errorf("expected the cookie to be signed again but got: %t, %v", signed, err)
+		}
+
+		forged, err := EncodeCookieValue(CookieAssignments{"experiment_key": {Alternative: "variant", Version: 2}}, []byte("other"))
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		r = httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(&http.Cookie{Name: "swole", Value: forged})
+		if _, err := manager.StartExperiment("experiment_key", httptest.NewRecorder(), r); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("expected error to be %v but got: %v", ErrInvalidSignature, err)
+		}
+	})
+
+	t.Run("Identities are signed", func(t *testing.T) {
+		identity := func(value string) (string, *httptest.ResponseRecorder) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if len(value) > 0 {
+				r.AddCookie(&http.Cookie{Name: identityCookieName, Value: value})
+			}
+			id, err := store.Identity(w, r)
+			if err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+			return id, w
+		}
+
+		id, w := identity("")
+		signed := getExperimentCookie(t, w, identityCookieName).Value
+		if !strings.HasPrefix(signed, id+".") {
+			t.Errorf("expected the identity %s to be signed but got: %s", id, signed)
+		}
+		if again, _ := identity(signed); again != id {
+			t.Errorf("expected the signed identity %s to be kept but got: %s", id, again)
+		}
+
+		if chosen, _ := identity("chosen"); chosen == "chosen" {
+			t.Error("expected an unsigned identity to be replaced")
+		}
+
+		store.AcceptUnsigned = true
+		defer func() { store.AcceptUnsigned = false }()
+		chosen, w := identity("chosen")
+		if chosen != "chosen" || !strings.HasPrefix(getExperimentCookie(t, w, identityCookieName).Value, "chosen.") {
+			t.Errorf("expected the unsigned identity to be kept and signed during the migration but got: %s", chosen)
+		}
+	})
+}
+
+func TestSampleSize(t *testing.T) {
+	tests := []struct {
+		name           string
+		params         SampleSizeParams
+		wantSampleSize []int
+		wantDays       int
+		wantErr        error
+	}{
+		{
+			name: "Equal weights",
+			params: SampleSizeParams{
+				BaselineRate:            0.1,
+				MinimumDetectableEffect: 0.1,
+				Alternatives:            Alternatives{{Name: "control"}, {Name: "variant"}},
+				DailyTraffic:            1000,
+				TrafficAllocation:       0.5,
+			},
+			wantSampleSize: []int{14749, 14749},
+			wantDays:       59,
+		},
+		{
+			name: "Uneven weights need more participants",
+			params: SampleSizeParams{
+				BaselineRate:            0.1,
+				MinimumDetectableEffect: 0.1,
+				Alternatives:            Alternatives{{Name: "control", Weight: 3}, {Name: "variant", Weight: 1}},
+			},
+			wantSampleSize: []int{30117, 10039},
+		},
+		{
+			name: "Invalid baseline",
+			params: SampleSizeParams{
+				BaselineRate:            1.5,
+				MinimumDetectableEffect: 0.1,
+				Alternatives:            Alternatives{{Name: "control"}, {Name: "variant"}},
+			},
+			wantErr: ErrInvalidParameter,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			estimate, err := SampleSize(tt.params)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error to be %v but got: %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			for i, want := range tt.wantSampleSize {
+				if got := estimate.Alternatives[i].SampleSize; got != want {
+					t.Errorf("expected a sample size of %d for %s but got: %d", want, estimate.Alternatives[i].Name, got)
+				}
+			}
+			if estimate.Days != tt.wantDays {
+				t.Errorf("expected %d days but got: %d", tt.wantDays, estimate.Days)
+			}
+		})
+	}
+}
+
+func TestSequentialTest(t *testing.T) {
+	tests := []struct {
+		name               string
+		control            AlternativeResult
+		variant            AlternativeResult
+		wantRecommendation Recommendation
+	}{
+		{
+			name:               "No participants",
+			wantRecommendation: RecommendContinue,
+		},
+		{
+			name:               "Small difference",
+			control:            AlternativeResult{Name: "control", Participants: 1000, Completions: 100},
+			variant:            AlternativeResult{Name: "variant", Participants: 1000, Completions: 110},
+			wantRecommendation: RecommendContinue,
+		},
+		{
+			name:               "Large difference",
+			control:            AlternativeResult{Name: "control", Participants: 10000, Completions: 1000},
+			variant:            AlternativeResult{Name: "variant", Participants: 10000, Completions: 1300},
+			wantRecommendation: RecommendStop,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := &ExperimentResults{Key: "experiment_key", Alternatives: []AlternativeResult{tt.control, tt.variant}}
+
+			test, err := results.SequentialTest(SequentialParams{})
+			if err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+
+			if test.Recommendation != tt.wantRecommendation {
+				t.Errorf("expected recommendation to be %s but got: %s", tt.wantRecommendation, test.Recommendation)
+			}
+			if p := test.Comparisons[0].PValue; p < 0 || p > 1 {
+				t.Errorf("expected a p-value between 0 and 1 but got: %f", p)
+			}
+		})
+	}
+
+	if _, err := (&ExperimentResults{}).SequentialTest(SequentialParams{Alpha: 2}); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("expected error to be %v but got: %v", ErrInvalidParameter, err)
+	}
+}
+
+func TestFinishWithValue(t *testing.T) {
+	var events bytes.Buffer
+
+	manager := NewExperimentManager()
+	manager.EventSink = NewJSONLinesEventSink(&events)
+	experiment := Experiment{
+		Key:          "experiment_key",
+		Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}},
+	}
+	manager.RegisterExperiment(experiment)
+
+	ctx := context.Background()
+	if _, err := manager.FinishWithValue(ctx, "user_1", "experiment_key", "", 10); !errors.Is(err, ErrMissingGoal) {
+		t.Errorf("expected error to be %v but got: %v", ErrMissingGoal, err)
+	}
+	if _, err := manager.FinishWithValue(ctx, "user_1", "experiment_key", "order", math.NaN()); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("expected error to be %v but got: %v", ErrInvalidValue, err)
+	}
+
+	// participants that were not enrolled do not record values
+	response, err := manager.FinishWithValue(ctx, "user_0", "experiment_key", "order", 10)
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if response.DidFinish {
+		t.Errorf("expected not to finish without being enrolled")
+	}
+
+	for i := range 40 {
+		subject := fmt.Sprintf("user_%d", i)
+		response, err := manager.Start(ctx, subject, "experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+
+		value := float64(10 + i%5)
+		if response.Alternative == "variant" {
+			value += 20
+		}
+		// values are recorded every time, completions only once
+		for range 2 {
+			if _, err := manager.FinishWithValue(ctx, subject, "experiment_key", "order", value); err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+		}
+	}
+	if _, err := manager.FinishWithValue(ctx, "user_0", "experiment_key", "order", 10000); err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+
+	results, err := manager.GetResults("experiment_key")
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if results.Completions != 40 {
+		t.Errorf("expected 40 completions but got: %d", results.Completions)
+	}
+
+	values, err := manager.GetValueResults("experiment_key", "order", ValueParams{Cap: 40, Seed: 1})
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	control, variant := values.Alternatives[0], values.Alternatives[1]
+	if control.Count+variant.Count != 81 {
+		t.Errorf("expected 81 values but got: %d", control.Count+variant.Count)
+	}
+	for _, a := range values.Alternatives {
+		if a.ConfidenceLow > a.Mean || a.ConfidenceHigh < a.Mean {
+			t.Errorf("expected the confidence interval of %s to contain the mean %f but got: [%f, %f]", a.Name, a.Mean, a.ConfidenceLow, a.ConfidenceHigh)
+		}
+	}
+	if !variant.Significant || variant.PValue > 0.001 {
+		t.Errorf("expected the variant to be significantly different but got: %+v", variant)
+	}
+
+	fromEvents, err := ValueResultsFromEvents(bytes.NewReader(events.Bytes()), experiment, "order", ValueParams{Cap: 40, Seed: 1})
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if fromEvents.Alternatives[1].Mean != variant.Mean || fromEvents.Alternatives[0].Mean != control.Mean {
+		t.Errorf("expected the events to give the same means but got: %+v", fromEvents.Alternatives)
+	}
+}
+
+func TestAnalyzeValues(t *testing.T) {
+	experiment := Experiment{Key: "experiment_key", Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}}}
+	values := map[string][]float64{
+		"control": {1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		"variant": {3, 4, 5, 6, 7, 8, 9, 10, 11, 12},
+	}
+
+	results, err := AnalyzeValues(experiment, "order", values, ValueParams{})
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+
+	variant := results.Alternatives[1]
+	if math.Abs(variant.TStatistic-1.4771) > 1e-4 || variant.DegreesOfFreedom != 18 || math.Abs(variant.PValue-0.1569) > 1e-4 {
+		t.Errorf("expected t = 1.4771 with 18 degrees of freedom and p = 0.1569 but got: %+v", variant)
+	}
+
+	capped, err := AnalyzeValues(experiment, "order", values, ValueParams{CapPercentile: 0.9})
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if capped.Cap != 10 || capped.Alternatives[1].Mean != 7.2 {
+		t.Errorf("expected the values to be capped at 10 but got: %+v", capped)
+	}
+
+	t.Run("stored values are sampled", func(t *testing.T) {
+		store := NewMemoryTrackingStore()
+		store.MaxValues = 100
+		for i := range 1000 {
+			store.AddValue("experiment_key", "control", "order", float64(i))
+		}
+
+		stored, err := store.Values("experiment_key", "order")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if len(stored["control"]) != 100 || slices.Max(stored["control"]) < 100 {
+			t.Errorf("expected a sample of 100 values of the whole range but got: %v", stored["control"])
+		}
+	})
+
+	t.Run("large samples are not bootstrapped", func(t *testing.T) {
+		large := make(map[string][]float64)
+		for i := range 20000 {
+			large["control"] = append(large["control"], float64(i%2))
+			large["variant"] = append(large["variant"], float64(i%2))
+		}
+
+		results, err := AnalyzeValues(experiment, "order", large, ValueParams{})
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		control := results.Alternatives[0]
+		margin := 1.959964 * math.Sqrt(control.Variance/20000)
+		if math.Abs(control.ConfidenceLow-(0.5-margin)) > 1e-6 || math.Abs(control.ConfidenceHigh-(0.5+margin)) > 1e-6 {
+			t.Errorf("expected the normal interval 0.5 ± %f but got: %+v", margin, control)
+		}
+	})
+}
+
+func TestSampleRatioMismatch(t *testing.T) {
+	var events, logs bytes.Buffer
+
+	manager := NewExperimentManager()
+	manager.EventSink = NewJSONLinesEventSink(&events)
+	manager.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+	manager.SampleRatioCheckInterval = 10
+	manager.RegisterExperiment(Experiment{
+		Key:          "experiment_key",
+		Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}},
+	})
+
+	ctx := context.Background()
+	check, err := manager.CheckSampleRatio(ctx, "experiment_key")
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if check.Mismatch || check.PValue != 1 {
+		t.Errorf("expected no mismatch without participants but got: %+v", check)
+	}
+
+	for i := range 2000 {
+		alternative := "control"
+		if i%20 < 11 {
+			alternative = "variant"
+		}
+		manager.TrackingStore.AddParticipant("experiment_key", alternative, 0)
+	}
+
+	results, err := manager.GetResults("experiment_key")
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if !results.SampleRatio.Mismatch || math.Abs(results.SampleRatio.ChiSquared-20) > 1e-9 || math.Abs(results.SampleRatio.PValue-7.744e-6) > 1e-8 {
+		t.Errorf("expected a mismatch with a chi-squared of 20 and p = 7.744e-6 but got: %+v", results.SampleRatio)
+	}
+
+	// the automatic check alerts once
+	for i := range 20 {
+		if _, err := manager.Start(ctx, fmt.Sprintf("user_%d", i), "experiment_key"); err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+	}
+	manager.Wait()
+
+	if got := strings.Count(events.String(), `"type":"sample_ratio_mismatch"`); got != 1 {
+		t.Errorf("expected a single sample ratio mismatch event but got: %d", got)
+	}
+	if got := strings.Count(logs.String(), "swole: sample ratio mismatch"); got != 1 {
+		t.Errorf("expected a single sample ratio mismatch log but got: %d", got)
+	}
+}
+
+func TestSampleRatioAllocators(t *testing.T) {
+	tests := []struct {
+		name                string
+		managerAllocator    Allocator
+		experimentAllocator Allocator
+		wantMismatch        bool
+	}{
+		{name: "Default allocator", managerAllocator: nil, wantMismatch: true},
+		{name: "Weighted manager allocator", managerAllocator: HashAllocator{}, wantMismatch: true},
+		{name: "Bandit manager allocator", managerAllocator: ThompsonSampling{}, wantMismatch: false},
+		{name: "Weighted experiment allocator", managerAllocator: ThompsonSampling{}, experimentAllocator: &RoundRobin{}, wantMismatch: true},
+		{name: "Bandit experiment allocator", managerAllocator: WeightedRandom{}, experimentAllocator: EpsilonGreedy{}, wantMismatch: false},
+		{
+			name:                "Custom experiment allocator",
+			experimentAllocator: allocatorFunc(func(ctx context.Context, allocation AllocationContext) string { return "variant" }),
+			wantMismatch:        false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := NewExperimentManager()
+			manager.Allocator = tt.managerAllocator
+			manager.RegisterExperiment(Experiment{
+				Key:          "experiment_key",
+				Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}},
+				Allocator:    tt.experimentAllocator,
+			})
+
+			manager.TrackingStore.AddParticipant("experiment_key", "control", 0)
+			for range 100 {
+				manager.TrackingStore.AddParticipant("experiment_key", "variant", 0)
+			}
+
+			results, err := manager.GetResults("experiment_key")
+			if err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+			if results.SampleRatio.Mismatch != tt.wantMismatch {
+				t.Errorf("expected mismatch to be %t but got: %+v", tt.wantMismatch, results.SampleRatio)
+			}
+		})
+	}
+}
+
+func TestAllocators(t *testing.T) {
+	tests := []struct {
+		name      string
+		allocator Allocator
+	}{
+		{name: "Thompson sampling", allocator: ThompsonSampling{}},
+		{name: "Epsilon greedy", allocator: EpsilonGreedy{Epsilon: -1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+
+			manager := NewExperimentManager()
+			manager.Now = func() time.Time { return now }
+			manager.RegisterExperiment(Experiment{
+				Key:          "experiment_key",
+				Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}},
+				Allocator:    tt.allocator,
+			})
+
+			for i := range 1000 {
+				manager.TrackingStore.AddParticipant("experiment_key", "control", 0)
+				manager.TrackingStore.AddParticipant("experiment_key", "variant", 0)
+				if i%10 == 0 {
+					manager.TrackingStore.AddCompletion("experiment_key", "control")
+				}
+				if i%2 == 0 {
+					manager.TrackingStore.AddCompletion("experiment_key", "variant")
+				}
+			}
+
+			ctx := context.Background()
+			// existing participants keep their alternative
+			manager.AssignmentStore.PersistExperiment(ctx, "existing", "experiment_key", "control", 0)
+			response, err := manager.Start(ctx, "existing", "experiment_key")
+			if err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+			if response.Alternative != "control" || response.DidStartFirstTime {
+				t.Errorf("expected the existing participant to keep the control but got: %+v", response)
+			}
+
+			for i := range 50 {
+				response, err := manager.Start(ctx, fmt.Sprintf("user_%d", i), "experiment_key")
+				if err != nil {
+					t.Fatalf("expected not to error but got: %v", err)
+				}
+				if response.Alternative != "variant" {
+					t.Fatalf("expected new participants to be allocated to the variant but got: %s", response.Alternative)
+				}
+			}
+
+			// the results are refreshed once the interval has passed
+			for range 3000 {
+				manager.TrackingStore.AddCompletion("experiment_key", "control")
+			}
+			if response, _ := manager.Start(ctx, "before_refresh", "experiment_key"); response.Alternative != "variant" {
+				t.Errorf("expected the results not to be refreshed before the interval but got: %s", response.Alternative)
+			}
+			now = now.Add(DefaultAllocationInterval)
+			if response, _ := manager.Start(ctx, "after_refresh", "experiment_key"); response.Alternative != "control" {
+				t.Errorf("expected the results to be refreshed after the interval but got: %s", response.Alternative)
+			}
+		})
+	}
+
+	t.Run("Epsilon greedy shares the ties", func(t *testing.T) {
+		results := &ExperimentResults{Alternatives: []AlternativeResult{
+			{Name: "control", Participants: 10, Completions: 5},
+			{Name: "variant", Participants: 20, Completions: 10},
+		}}
+		allocation := AllocationContext{
+			Experiment: Experiment{Key: "experiment_key", Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}}},
+			Results:    func() *ExperimentResults { return results },
+		}
+
+		counts := make(map[string]int)
+		for range 1000 {
+			counts[EpsilonGreedy{Epsilon: -1}.Allocate(context.Background(), allocation)]++
+		}
+		if counts["control"] < 400 || counts["variant"] < 400 {
+			t.Errorf("expected the tied alternatives to share the participants but got: %v", counts)
+		}
+	})
+}
+
+type allocatorFunc func(ctx context.Context, allocation AllocationContext) string
+
+func (f allocatorFunc) Allocate(ctx context.Context, allocation AllocationContext) string {
+	return f(ctx, allocation)
+}
+
+func TestAllocationStrategies(t *testing.T) {
+	alternatives := Alternatives{{Name: "control", Weight: 1}, {Name: "variant", Weight: 2}}
+
+	t.Run("Round robin respects the weights", func(t *testing.T) {
+		manager := NewExperimentManager()
+		manager.Allocator = &RoundRobin{}
+		manager.RegisterExperiment(Experiment{Key: "experiment_key", Alternatives: alternatives})
+
+		var got []string
+		for i := range 6 {
+			response, err := manager.Start(context.Background(), fmt.Sprintf("user_%d", i), "experiment_key")
+			if err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+			got = append(got, response.Alternative)
+		}
+
+		want := []string{"control", "variant", "variant", "control", "variant", "variant"}
+		if !slices.Equal(got, want) {
+			t.Errorf("expected %v but got: %v", want, got)
+		}
+	})
+
+	t.Run("Hash allocation is deterministic", func(t *testing.T) {
+		counts := make(map[string]int)
+		for i := range 300 {
+			subject := fmt.Sprintf("user_%d", i)
+
+			var allocated []string
+			for range 2 {
+				manager := NewExperimentManager()
+				manager.Allocator = HashAllocator{}
+				manager.RegisterExperiment(Experiment{Key: "experiment_key", Alternatives: alternatives})
+
+				response, err := manager.Start(context.Background(), subject, "experiment_key")
+				if err != nil {
+					t.Fatalf("expected not to error but got: %v", err)
+				}
+				allocated = append(allocated, response.Alternative)
+			}
+			if allocated[0] != allocated[1] {
+				t.Fatalf("expected %s to get the same alternative but got: %v", subject, allocated)
+			}
+			counts[allocated[0]]++
+		}
+
+		if counts["variant"] < 150 || counts["variant"] > 250 {
+			t.Errorf("expected about two thirds of the participants in the variant but got: %v", counts)
+		}
+	})
+
+	t.Run("Hash allocation creates the identity of http participants", func(t *testing.T) {
+		manager := NewExperimentManager()
+		manager.Allocator = HashAllocator{}
+		manager.RegisterExperiment(Experiment{Key: "experiment_key", Alternatives: alternatives})
+
+		w := httptest.NewRecorder()
+		response, err := manager.StartExperiment("experiment_key", w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+
+		id := getExperimentCookie(t, w, "swole_id").Value
+		if want := (Experiment{Key: "experiment_key", Alternatives: alternatives}).alternativeAt(bucket("experiment_key", id, 3)); response.Alternative != want {
+			t.Errorf("expected the alternative of the identity %s but got: %s", want, response.Alternative)
+		}
+	})
+
+	t.Run("Custom allocators", func(t *testing.T) {
+		manager := NewExperimentManager()
+		manager.RegisterExperiment(Experiment{
+			Key:          "experiment_key",
+			Alternatives: alternatives,
+			Allocator: allocatorFunc(func(ctx context.Context, allocation AllocationContext) string {
+				if allocation.Request != nil && allocation.Request.Header.Get("X-Beta") == "true" {
+					return "variant"
+				}
+				return "unknown"
+			}),
+		})
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Beta", "true")
+		response, err := manager.StartExperiment("experiment_key", httptest.NewRecorder(), r)
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if response.Alternative != "variant" {
+			t.Errorf("expected the variant but got: %s", response.Alternative)
+		}
+
+		// unknown alternatives fall back to the weights
+		response, err = manager.Start(context.Background(), "user_1", "experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if !slices.Contains([]string{"control", "variant"}, response.Alternative) {
+			t.Errorf("expected one of the alternatives but got: %s", response.Alternative)
+		}
+	})
+}
+
+func TestMultivariateExperiment(t *testing.T) {
+	factors := []Factor{
+		{Key: "headline", Alternatives: Alternatives{{Name: "short", Payload: json.RawMessage(`"Buy"`)}, {Name: "long", Payload: json.RawMessage(`"Buy it now"`)}}},
+		{Key: "cta", Alternatives: Alternatives{{Name: "blue", Weight: 1}, {Name: "green", Weight: 3}}},
+	}
+
+	tests := []struct {
+		name       string
+		experiment Experiment
+	}{
+		{
+			name:       "Factors and alternatives",
+			experiment: Experiment{Key: "experiment_key", Factors: factors, Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}}},
+		},
+		{
+			name:       "Duplicate factors",
+			experiment: Experiment{Key: "experiment_key", Factors: []Factor{factors[0], factors[0]}},
+		},
+		{
+			name:       "Factor with a single alternative",
+			experiment: Experiment{Key: "experiment_key", Factors: []Factor{factors[0], {Key: "cta", Alternatives: Alternatives{{Name: "blue"}}}}},
+		},
+		{
+			name:       "Unknown winner",
+			experiment: Experiment{Key: "experiment_key", Factors: factors, Winner: "short/red"},
+		},
+		{
+			name:       "Alternative containing the separator",
+			experiment: Experiment{Key: "experiment_key", Factors: []Factor{factors[0], {Key: "cta", Alternatives: Alternatives{{Name: "blue/green"}, {Name: "red"}}}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertPanic(t, func() {
+				NewExperimentManager().RegisterExperiment(tt.experiment)
+			})
+		})
+	}
+
+	manager := NewExperimentManager()
+	manager.RegisterExperiment(Experiment{Key: "experiment_key", Factors: factors})
+
+	experiment := manager.GetRegisterExperiments()["experiment_key"]
+	var names []string
+	var weights []int
+	for _, a := range experiment.Alternatives {
+		names = append(names, a.Name)
+		weights = append(weights, a.Weight)
+	}
+	if !slices.Equal(names, []string{"short/blue", "short/green", "long/blue", "long/green"}) || !slices.Equal(weights, []int{1, 3, 1, 3}) {
+		t.Errorf("expected a combination for every alternative of the factors but got: %v with weights %v", names, weights)
+	}
+
+	// the registered experiment can be registered again, for example after a round trip through JSON
+	encoded, err := json.Marshal(experiment)
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	var decoded Experiment
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if err := decoded.Validate(); err != nil {
+		t.Errorf("expected the registered experiment to be valid but got: %v", err)
+	}
+
+	ctx := context.Background()
+	for i := range 200 {
+		subject := fmt.Sprintf("user_%d", i)
+		response, err := manager.Start(ctx, subject, "experiment_key")
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+
+		name, err := experiment.CombinationName(response.Combination)
+		if err != nil || name != response.Alternative {
+			t.Fatalf("expected the combination %v to be named %s but got: %s, %v", response.Combination, response.Alternative, name, err)
+		}
+		var payload map[string]string
+		if err := json.Unmarshal(response.Payload, &payload); err != nil || len(payload["headline"]) == 0 {
+			t.Fatalf("expected the payload of the headline but got: %s", response.Payload)
+		}
+
+		if response.Combination["cta"] == "green" {
+			finish, err := manager.Finish(ctx, subject, "experiment_key")
+			if err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+			if !maps.Equal(finish.Combination, response.Combination) {
+				t.Errorf("expected the finished combination to be %v but got: %v", response.Combination, finish.Combination)
+			}
+		}
+	}
+
+	results, err := manager.GetResults("experiment_key")
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if len(results.Factors) != 2 || results.Factors[1].Key != "cta" {
+		t.Fatalf("expected the main effects of both factors but got: %+v", results.Factors)
+	}
+	for _, factor := range results.Factors {
+		total := 0
+		for _, a := range factor.Alternatives {
+			total += a.Participants
+		}
+		if total != 200 {
+			t.Errorf("expected the alternatives of %s to have 200 participants but got: %d", factor.Key, total)
+		}
+	}
+	blue, green := results.Factors[1].Alternatives[0], results.Factors[1].Alternatives[1]
+	if blue.Completions != 0 || green.Completions != green.Participants {
+		t.Errorf("expected only the green participants to complete but got: %+v and %+v", blue, green)
+	}
+
+	// reordering the alternatives of a factor keeps the combinations of the participants
+	response, err := manager.Start(ctx, "user_0", "experiment_key")
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	reordered := NewExperimentManager()
+	reordered.AssignmentStore = manager.AssignmentStore
+	reordered.RegisterExperiment(Experiment{Key: "experiment_key", Factors: []Factor{
+		{Key: "headline", Alternatives: Alternatives{factors[0].Alternatives[1], factors[0].Alternatives[0]}},
+		factors[1],
+	}})
+
+	moved, err := reordered.Start(ctx, "user_0", "experiment_key")
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if moved.DidStartFirstTime || !maps.Equal(moved.Combination, response.Combination) {
+		t.Errorf("expected the combination %v to be kept but got: %+v", response.Combination, moved)
+	}
+}
+
+func TestHoldouts(t *testing.T) {
+	tests := []struct {
+		name    string
+		holdout Holdout
+	}{
+		{name: "Empty key", holdout: Holdout{Buckets: BucketRange{Start: 0, End: 100}}},
+		{name: "Duplicate key", holdout: Holdout{Key: "global", Buckets: BucketRange{Start: 0, End: 100}}},
+		{name: "Range out of the buckets", holdout: Holdout{Key: "other", Buckets: BucketRange{Start: 0, End: HoldoutBuckets + 1}}},
+		{name: "Empty range", holdout: Holdout{Key: "other", Buckets: BucketRange{Start: 100, End: 100}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := NewExperimentManager()
+			manager.RegisterHoldout(Holdout{Key: "global", Buckets: BucketRange{Start: 0, End: 100}})
+
+			assertPanic(t, func() {
+				manager.RegisterHoldout(tt.holdout)
+			})
+		})
+	}
+
+	var events bytes.Buffer
+
+	manager := NewExperimentManager()
+	manager.EventSink = NewJSONLinesEventSink(&events)
+	manager.RegisterHoldout(Holdout{Key: "global", Buckets: BucketRange{Start: 0, End: 1000}})
+	manager.RegisterHoldout(Holdout{Key: "checkout", Buckets: BucketRange{Start: 0, End: 1000}, Experiments: []string{"checkout"}})
+	for _, key := range []string{"checkout", "banner"} {
+		manager.RegisterExperiment(Experiment{
+			Key:          key,
+			Alternatives: Alternatives{{Name: "control"}, {Name: "variant"}},
+		})
+	}
+
+	ctx := context.Background()
+	heldOut := make(map[string]map[string]int)
+	for i := range 1000 {
+		subject := fmt.Sprintf("user_%d", i)
+		for _, key := range []string{"checkout", "banner"} {
+			// starting again neither enrolls nor tracks the participant twice
+			for range 2 {
+				response, err := manager.Start(ctx, subject, key)
+				if err != nil {
+					t.Fatalf("expected not to error but got: %v", err)
+				}
+
+				if len(response.Holdout) == 0 {
+					continue
+				}
+				if response.DidStart || response.Excluded != ExcludedHoldout || response.Alternative != "control" {
+					t.Fatalf("expected the held out participant to be served the control but got: %+v", response)
+				}
+				if response.Holdout == "checkout" && key != "checkout" {
+					t.Fatalf("expected the checkout holdout to apply only to the checkout experiment")
+				}
+			}
+
+			finish, err := manager.FinishWithValue(ctx, subject, key, "order", 10)
+			if err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+			if len(finish.Holdout) > 0 {
+				if finish.DidFinish {
+					t.Errorf("expected held out participants not to finish")
+				}
+				if heldOut[key] == nil {
+					heldOut[key] = make(map[string]int)
+				}
+				heldOut[key][finish.Holdout]++
+			}
+		}
+	}
+
+	if heldOut["banner"]["global"] != heldOut["checkout"]["global"] || heldOut["banner"]["global"] < 50 || heldOut["banner"]["global"] > 150 {
+		t.Errorf("expected about 10%% of the participants to be held out of both experiments but got: %v", heldOut)
+	}
+
+	for _, key := range []string{"checkout", "banner"} {
+		results, err := manager.GetResults(key)
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+
+		total := results.Participants
+		for _, h := range results.Holdouts {
+			if h.Participants != heldOut[key][h.Name] || h.Completions != heldOut[key][h.Name] {
+				t.Errorf("expected %d participants and completions in the %s holdout of %s but got: %+v", heldOut[key][h.Name], h.Name, key, h)
+			}
+			total += h.Participants
+
+			holdout := Holdout{Key: h.Name}
+			values, err := manager.TrackingStore.Values(holdout.trackingKey(key), "order")
+			if err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+			if len(values["control"]) != h.Participants {
+				t.Errorf("expected a value for every participant of the %s holdout of %s but got: %d", h.Name, key, len(values["control"]))
+			}
+
+			for _, eventType := range []EventType{EventExposure, EventGoal, EventValue} {
+				want := fmt.Sprintf(`"type":"%s"`, eventType)
+				got := 0
+				for _, line := range strings.Split(events.String(), "\n") {
+					if strings.Contains(line, want) && strings.Contains(line, `"experiment":"`+key+`"`) && strings.Contains(line, `"holdout":"`+h.Name+`"`) {
+						got++
+					}
+				}
+				if got != h.Participants {
+					t.Errorf("expected a %s event for every participant of the %s holdout of %s but got: %d", eventType, h.Name, key, got)
+				}
+			}
+		}
+		if total != 1000 {
+			t.Errorf("expected every participant of %s to be enrolled or held out but got: %d", key, total)
+		}
+		// the exclusion is counted once per held out participant
+		if results.Exclusions[ExcludedHoldout] != total-results.Participants {
+			t.Errorf("expected %d holdout exclusions of %s but got: %d", total-results.Participants, key, results.Exclusions[ExcludedHoldout])
+		}
+
+		// the events of the held out participants are not counted as enrolled ones
+		fromEvents, err := ResultsFromEvents(bytes.NewReader(events.Bytes()), manager.GetRegisterExperiments()[key])
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+		if fromEvents.Participants != results.Participants {
+			t.Errorf("expected %d participants of %s from the events but got: %d", results.Participants, key, fromEvents.Participants)
+		}
+	}
+}
+
 func assertPanic(t *testing.T, f func()) {
 	t.Helper()
 	defer func() {
@@ -364,6 +3135,19 @@ func newRequestFromResponse(rr *httptest.ResponseRecorder) *http.Request {
 	return r
 }
 
+func newRequestWithCookieValue(t *testing.T, value map[string]string) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	rawValue, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.AddCookie(&http.Cookie{Name: cookieName, Value: url.QueryEscape(string(rawValue))})
+
+	return r
+}
+
 func getExperimentCookieValue(t *testing.T, w *httptest.ResponseRecorder, cookieName string) map[string]string {
 	t.Helper()
 	cookie := getExperimentCookie(t, w, cookieName)
@@ -0,0 +1,154 @@
+package swole
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultAssignmentTtl matches the lifetime of the assignment cookie
+const DefaultAssignmentTtl = 24 * time.Hour
+
+type memoryAssignment struct {
+	alternative string
+	version     int
+	finished    bool
+}
+
+type memorySubject struct {
+	assignments map[string]memoryAssignment
+	seen        time.Time
+}
+
+// MemoryAssignmentStore keeps the assignments in memory, it is safe for concurrent use but it
+// cannot be shared between instances of the application. The assignments of a subject expire
+// once the subject was not seen for Ttl, like the assignment cookie does
+type MemoryAssignmentStore struct {
+	// Ttl is how long the assignments of a subject are kept after its last start, zero keeps them forever
+	Ttl time.Duration
+	// Now returns the current time, it defaults to time.Now
+	Now func() time.Time
+
+	mu        sync.Mutex
+	subjects  map[string]*memorySubject
+	lastSweep time.Time
+}
+
+func NewMemoryAssignmentStore() *MemoryAssignmentStore {
+	return &MemoryAssignmentStore{
+		Ttl:      DefaultAssignmentTtl,
+		Now:      time.Now,
+		subjects: make(map[string]*memorySubject),
+	}
+}
+
+func (s *MemoryAssignmentStore) expired(subject *memorySubject, now time.Time) bool {
+	return s.Ttl > 0 && now.Sub(subject.seen) >= s.Ttl
+}
+
+// getSubject returns the subject if its assignments have not expired, expired subjects are dropped
+func (s *MemoryAssignmentStore) getSubject(id string, now time.Time) (*memorySubject, bool) {
+	subject, found := s.subjects[id]
+	if !found {
+		return nil, false
+	}
+	if s.expired(subject, now) {
+		delete(s.subjects, id)
+		return nil, false
+	}
+
+	return subject, true
+}
+
+// sweep drops every expired subject, it runs at most once per Ttl so that subjects
+// that are never seen again do not stay in memory
+func (s *MemoryAssignmentStore) sweep(now time.Time) {
+	if s.Ttl <= 0 || now.Sub(s.lastSweep) < s.Ttl {
+		return
+	}
+	s.lastSweep = now
+
+	for id, subject := range s.subjects {
+		if s.expired(subject, now) {
+			delete(s.subjects, id)
+		}
+	}
+}
+
+func (s *MemoryAssignmentStore) ExperimentExists(ctx context.Context, subject, key string) (bool, string, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub, found := s.getSubject(subject, s.Now())
+	if !found {
+		return false, "", 0, nil
+	}
+	assignment, found := sub.assignments[key]
+	if !found {
+		return false, "", 0, nil
+	}
+
+	return true, assignment.alternative, assignment.version, nil
+}
+
+func (s *MemoryAssignmentStore) PersistExperiment(ctx context.Context, subject, key, alternative string, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.Now()
+	s.sweep(now)
+
+	sub, found := s.getSubject(subject, now)
+	if !found {
+		sub = &memorySubject{assignments: make(map[string]memoryAssignment)}
+		s.subjects[subject] = sub
+	}
+	sub.seen = now
+	sub.assignments[key] = memoryAssignment{
+		alternative: alternative,
+		version:     version,
+	}
+
+	return nil
+}
+
+func (s *MemoryAssignmentStore) RefreshTtl(ctx context.Context, subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.Now()
+	if sub, found := s.getSubject(subject, now); found {
+		sub.seen = now
+	}
+
+	return nil
+}
+
+func (s *MemoryAssignmentStore) ExperimentFinish(ctx context.Context, subject, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub, found := s.getSubject(subject, s.Now())
+	if !found {
+		return false, ErrAssignmentNotFound
+	}
+	assignment, found := sub.assignments[key]
+	if !found {
+		return false, ErrAssignmentNotFound
+	}
+
+	finishFirstTime := !assignment.finished
+	assignment.finished = true
+	sub.assignments[key] = assignment
+
+	return finishFirstTime, nil
+}
+
+func (s *MemoryAssignmentStore) Reset(ctx context.Context, subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.subjects, subject)
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package swole
+
+import (
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"sync"
+)
+
+// DefaultMaxValues is the number of values a MemoryTrackingStore keeps per alternative and goal
+const DefaultMaxValues = 10000
+
+// MemoryTrackingStore keeps the counts in memory, it is safe for concurrent use
+// but it cannot be shared between instances of the application, see SQLTrackingStore
+type MemoryTrackingStore struct {
+	// MaxValues bounds the values kept per alternative and goal, past it a uniform sample of
+	// all the values is kept. Zero keeps every value
+	MaxValues int
+
+	mu         sync.Mutex
+	counts     map[string]map[string]AlternativeCounts
+	exclusions map[string]map[ExclusionReason]int
+	// values are keyed by experiment, goal and alternative
+	values map[string]map[string]map[string]*valueReservoir
+}
+
+// valueReservoir keeps a uniform sample of the values with reservoir sampling
+type valueReservoir struct {
+	values []float64
+	seen   int
+}
+
+func (r *valueReservoir) add(value float64, capacity int) {
+	r.seen++
+	if capacity <= 0 || len(r.values) < capacity {
+		r.values = append(r.values, value)
+		return
+	}
+
+	// every value seen so far ends up in the sample with the same probability
+	if i := rand.IntN(r.seen); i < capacity {
+		r.values[i] = value
+	}
+}
+
+func NewMemoryTrackingStore() *MemoryTrackingStore {
+	return &MemoryTrackingStore{
+		MaxValues:  DefaultMaxValues,
+		counts:     make(map[string]map[string]AlternativeCounts),
+		exclusions: make(map[string]map[ExclusionReason]int),
+		values:     make(map[string]map[string]map[string]*valueReservoir),
+	}
+}
+
+func (s *MemoryTrackingStore) experimentCounts(key string) map[string]AlternativeCounts {
+	counts, found := s.counts[key]
+	if !found {
+		counts = make(map[string]AlternativeCounts)
+		s.counts[key] = counts
+	}
+
+	return counts
+}
+
+func (s *MemoryTrackingStore) AddParticipant(key, alternative string, limit int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := s.experimentCounts(key)
+
+	if limit > 0 {
+		total := 0
+		for _, c := range counts {
+			total += c.Participants
+		}
+		if total >= limit {
+			return false, nil
+		}
+	}
+
+	c := counts[alternative]
+	c.Participants++
+	counts[alternative] = c
+
+	return true, nil
+}
+
+func (s *MemoryTrackingStore) RemoveParticipant(key, alternative string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := s.experimentCounts(key)
+	c := counts[alternative]
+	if c.Participants > 0 {
+		c.Participants--
+	}
+	counts[alternative] = c
+
+	return nil
+}
+
+func (s *MemoryTrackingStore) AddCompletion(key, alternative string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := s.experimentCounts(key)
+	c := counts[alternative]
+	c.Completions++
+	counts[alternative] = c
+
+	return nil
+}
+
+func (s *MemoryTrackingStore) AddValue(key, alternative, goal string, value float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	goals, found := s.values[key]
+	if !found {
+		goals = make(map[string]map[string]*valueReservoir)
+		s.values[key] = goals
+	}
+	alternatives, found := goals[goal]
+	if !found {
+		alternatives = make(map[string]*valueReservoir)
+		goals[goal] = alternatives
+	}
+	reservoir, found := alternatives[alternative]
+	if !found {
+		reservoir = &valueReservoir{}
+		alternatives[alternative] = reservoir
+	}
+	reservoir.add(value, s.MaxValues)
+
+	return nil
+}
+
+func (s *MemoryTrackingStore) AddExclusion(key string, reason ExclusionReason) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exclusions, found := s.exclusions[key]
+	if !found {
+		exclusions = make(map[ExclusionReason]int)
+		s.exclusions[key] = exclusions
+	}
+	exclusions[reason]++
+
+	return nil
+}
+
+func (s *MemoryTrackingStore) Counts(key string) (map[string]AlternativeCounts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return maps.Clone(s.counts[key]), nil
+}
+
+func (s *MemoryTrackingStore) Exclusions(key string) (map[ExclusionReason]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return maps.Clone(s.exclusions[key]), nil
+}
+
+func (s *MemoryTrackingStore) Values(key, goal string) (map[string][]float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	values := make(map[string][]float64, len(s.values[key][goal]))
+	for alternative, reservoir := range s.values[key][goal] {
+		values[alternative] = slices.Clone(reservoir.values)
+	}
+
+	return values, nil
+}
@@ -0,0 +1,194 @@
+package swole
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// combinationSeparator separates the names of the alternatives of every factor in the name
+// of a combination, `long/green` is the long alternative of the first factor and the green
+// one of the second. Names do not depend on the order of the alternatives, so reordering them
+// does not move the participants to another combination
+const combinationSeparator = "/"
+
+// Factor is an independent dimension of a multivariate experiment, like the headline of a page
+type Factor struct {
+	Key          string       `json:"key"`
+	Alternatives Alternatives `json:"alternatives"`
+}
+
+// FactorResult aggregates the results of every combination by the alternatives of a factor,
+// they are the main effects of the factor
+type FactorResult struct {
+	Key          string
+	Alternatives []AlternativeResult
+}
+
+// validateFactors checks the factors of a multivariate experiment, the alternatives of the
+// experiment are generated from them so they can only be set to the generated ones, like
+// the registered experiments have them
+func (e Experiment) validateFactors() error {
+	keys := make([]string, 0, len(e.Factors))
+	for _, f := range e.Factors {
+		if len(f.Key) == 0 {
+			return &InvalidExperimentError{
+				message: "the key of a factor cannot be empty",
+				key:     e.Key,
+			}
+		}
+		keys = append(keys, f.Key)
+
+		// factors are validated as experiments of their own
+		var invalid *InvalidExperimentError
+		err := Experiment{Key: f.Key, Alternatives: f.Alternatives}.Validate()
+		if errors.As(err, &invalid) {
+			return &InvalidExperimentError{
+				message: fmt.Sprintf("factor `%s`: %s", f.Key, invalid.message),
+				key:     e.Key,
+			}
+		}
+
+		for _, a := range f.Alternatives {
+			if strings.Contains(a.Name, combinationSeparator) {
+				return &InvalidExperimentError{
+					message: fmt.Sprintf("factor `%s`: alternative `%s` cannot contain `%s`", f.Key, a.Name, combinationSeparator),
+					key:     e.Key,
+				}
+			}
+		}
+	}
+
+	if !unique(keys) {
+		return &InvalidExperimentError{
+			message: "factors must be unique",
+			key:     e.Key,
+		}
+	}
+
+	if len(e.Alternatives) > 0 {
+		generated := e.expandFactors().Alternatives
+		equal := slices.EqualFunc(e.Alternatives, generated, func(a, b Alternative) bool {
+			return a.Name == b.Name && a.Weight == b.Weight
+		})
+		if !equal {
+			return &InvalidExperimentError{
+				message: "multivariate experiments cannot define alternatives, they are generated from the factors",
+				key:     e.Key,
+			}
+		}
+	}
+
+	return nil
+}
+
+// expandFactors returns the experiment with an alternative for every combination of the
+// alternatives of its factors. The weight of a combination is the product of the weights
+// of its alternatives and its payload is an object with the payload of every factor.
+// Experiments without factors are returned as they are
+func (e Experiment) expandFactors() Experiment {
+	if len(e.Factors) == 0 {
+		return e
+	}
+
+	combinations := []Alternative{{Weight: 1}}
+	payloads := []map[string]json.RawMessage{{}}
+
+	for _, f := range e.Factors {
+		next := make([]Alternative, 0, len(combinations)*len(f.Alternatives))
+		nextPayloads := make([]map[string]json.RawMessage, 0, cap(next))
+		for i, c := range combinations {
+			for _, a := range f.Alternatives {
+				name := a.Name
+				if len(c.Name) > 0 {
+					name = c.Name + combinationSeparator + name
+				}
+				next = append(next, Alternative{Name: name, Weight: c.Weight * max(a.Weight, 1)})
+
+				payload := make(map[string]json.RawMessage, len(payloads[i])+1)
+				for k, v := range payloads[i] {
+					payload[k] = v
+				}
+				if a.Payload != nil {
+					payload[f.Key] = a.Payload
+				}
+				nextPayloads = append(nextPayloads, payload)
+			}
+		}
+		combinations, payloads = next, nextPayloads
+	}
+
+	for i := range combinations {
+		if len(payloads[i]) > 0 {
+			// the payloads were validated so they can always be marshalled
+			combinations[i].Payload, _ = json.Marshal(payloads[i])
+		}
+	}
+	e.Alternatives = combinations
+
+	return e
+}
+
+// combination decodes the name of a combination into the alternative of every factor,
+// it returns nil for experiments without factors
+func (e Experiment) combination(name string) map[string]string {
+	if len(e.Factors) == 0 || len(name) == 0 {
+		return nil
+	}
+
+	names := strings.Split(name, combinationSeparator)
+	if len(names) != len(e.Factors) {
+		return nil
+	}
+
+	combination := make(map[string]string, len(e.Factors))
+	for i, f := range e.Factors {
+		if !slices.ContainsFunc(f.Alternatives, func(a Alternative) bool { return a.Name == names[i] }) {
+			return nil
+		}
+		combination[f.Key] = names[i]
+	}
+
+	return combination
+}
+
+// CombinationName returns the name of the alternative of a multivariate experiment that
+// combines the given alternative of every factor, it can be used as a Winner
+func (e Experiment) CombinationName(combination map[string]string) (string, error) {
+	names := make([]string, 0, len(e.Factors))
+	for _, f := range e.Factors {
+		name := combination[f.Key]
+		if !slices.ContainsFunc(f.Alternatives, func(a Alternative) bool { return a.Name == name }) {
+			return "", fmt.Errorf("factor `%s` has no alternative `%s`", f.Key, name)
+		}
+		names = append(names, name)
+	}
+
+	return strings.Join(names, combinationSeparator), nil
+}
+
+// factorResults computes the main effects of every factor from the results of the combinations
+func (e Experiment) factorResults(combinations []AlternativeResult) []FactorResult {
+	factors := make([]FactorResult, 0, len(e.Factors))
+	for _, f := range e.Factors {
+		result := FactorResult{Key: f.Key, Alternatives: make([]AlternativeResult, 0, len(f.Alternatives))}
+		for _, a := range f.Alternatives {
+			result.Alternatives = append(result.Alternatives, AlternativeResult{Name: a.Name, Weight: max(a.Weight, 1)})
+		}
+
+		for _, c := range combinations {
+			name := e.combination(c.Name)[f.Key]
+			for i := range result.Alternatives {
+				if result.Alternatives[i].Name == name {
+					result.Alternatives[i].Participants += c.Participants
+					result.Alternatives[i].Completions += c.Completions
+				}
+			}
+		}
+		factors = append(factors, result)
+	}
+
+	return factors
+}
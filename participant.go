@@ -0,0 +1,138 @@
+package swole
+
+import (
+	"context"
+	"net/http"
+)
+
+// participant is what the core logic of the manager sees of a single participant, it hides
+// whether the state comes from an http request or from an assignment store
+type participant interface {
+	isBot() bool
+	// knownIdentity returns the identity of the participant if it is known, without creating one
+	knownIdentity() string
+	identity() (string, error)
+	experimentExists(key string) (exists bool, alternative string, version int, err error)
+	persistExperiment(key, alternative string, version int) error
+	refreshTtl() error
+	experimentFinish(key string) (finishFirstTime bool, err error)
+	reset() error
+	// request returns the request of the participant, if any
+	request() *http.Request
+}
+
+// requestParticipant is the participant of an http request. Its assignments go through the
+// same AssignmentStore contract as the context based api, by adapting the PersistenceStore
+type requestParticipant struct {
+	subjectParticipant
+	persistence PersistenceStore
+	detector    BotDetector
+	w           http.ResponseWriter
+	r           *http.Request
+}
+
+func (m *ExperimentManager) requestParticipant(w http.ResponseWriter, r *http.Request) participant {
+	var p participant = &requestParticipant{
+		subjectParticipant: subjectParticipant{
+			store: NewHTTPAssignmentStore(m.PersistenceStore),
+			ctx:   WithHTTP(r.Context(), w, r),
+		},
+		persistence: m.PersistenceStore,
+		detector:    m.BotDetector,
+		w:           w,
+		r:           r,
+	}
+	if m.Instrumentation != nil {
+		p = &instrumentedParticipant{participant: p, ctx: r.Context(), instrumentation: m.Instrumentation}
+	}
+
+	return p
+}
+
+func (p *requestParticipant) isBot() bool {
+	return p.detector != nil && p.detector.IsBot(p.r)
+}
+
+// knownIdentityStore is implemented by the persistence stores that can verify the identity
+// of a request without creating one
+type knownIdentityStore interface {
+	KnownIdentity(r *http.Request) string
+}
+
+func (p *requestParticipant) knownIdentity() string {
+	if store, ok := p.persistence.(knownIdentityStore); ok {
+		return store.KnownIdentity(p.r)
+	}
+
+	cookie, err := p.r.Cookie(identityCookieName)
+	if err != nil {
+		return ""
+	}
+
+	return cookie.Value
+}
+
+func (p *requestParticipant) identity() (string, error) {
+	return p.persistence.Identity(p.w, p.r)
+}
+
+// subjectParticipant adapts an AssignmentStore to a participant identified by a subject
+type subjectParticipant struct {
+	store   AssignmentStore
+	ctx     context.Context
+	subject string
+}
+
+func (m *ExperimentManager) subjectParticipant(ctx context.Context, subject string) participant {
+	var p participant = &subjectParticipant{
+		store:   m.AssignmentStore,
+		ctx:     ctx,
+		subject: subject,
+	}
+	if m.Instrumentation != nil {
+		p = &instrumentedParticipant{participant: p, ctx: ctx, instrumentation: m.Instrumentation}
+	}
+
+	return p
+}
+
+// subjects of the context based api are identified by the caller, who is responsible for excluding bots
+func (p *subjectParticipant) isBot() bool {
+	return false
+}
+
+func (p *subjectParticipant) knownIdentity() string {
+	return p.subject
+}
+
+func (p *subjectParticipant) identity() (string, error) {
+	return p.subject, nil
+}
+
+func (p *subjectParticipant) experimentExists(key string) (bool, string, int, error) {
+	return p.store.ExperimentExists(p.ctx, p.subject, key)
+}
+
+func (p *subjectParticipant) persistExperiment(key, alternative string, version int) error {
+	return p.store.PersistExperiment(p.ctx, p.subject, key, alternative, version)
+}
+
+func (p *subjectParticipant) refreshTtl() error {
+	return p.store.RefreshTtl(p.ctx, p.subject)
+}
+
+func (p *subjectParticipant) experimentFinish(key string) (bool, error) {
+	return p.store.ExperimentFinish(p.ctx, p.subject, key)
+}
+
+func (p *subjectParticipant) reset() error {
+	return p.store.Reset(p.ctx, p.subject)
+}
+
+func (p *requestParticipant) request() *http.Request {
+	return p.r
+}
+
+func (p *subjectParticipant) request() *http.Request {
+	return nil
+}
@@ -5,8 +5,16 @@ import (
 )
 
 type PersistenceStore interface {
-	ExperimentExists(key string, w http.ResponseWriter, r *http.Request) (exists bool, alternative string, err error)
-	PersistExperiment(key, alternative string, w http.ResponseWriter, r *http.Request) (err error)
+	// ExperimentExists reports whether the experiment was started, along with the alternative
+	// and the experiment version it was assigned under
+	ExperimentExists(key string, w http.ResponseWriter, r *http.Request) (exists bool, alternative string, version int, err error)
+	// PersistExperiment stores a fresh assignment, any previous finish of the experiment is cleared
+	PersistExperiment(key, alternative string, version int, w http.ResponseWriter, r *http.Request) (err error)
 	RefreshTtl(w http.ResponseWriter, r *http.Request) (err error)
 	ExperimentFinish(key string, w http.ResponseWriter, r *http.Request) (finishFirstTime bool, err error)
+	// Reset drops every assignment of the participant, it is used to recover from a corrupted state.
+	// Later calls while handling the same request must see the reset state
+	Reset(w http.ResponseWriter, r *http.Request) (err error)
+	// Identity returns a stable identifier of the participant, creating one if needed
+	Identity(w http.ResponseWriter, r *http.Request) (id string, err error)
 }
@@ -0,0 +1,101 @@
+package swole
+
+import (
+	"bufio"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// errorCounter counts the errors of the manager operations, keyed by operation and type
+type errorCounter struct {
+	mu     sync.Mutex
+	counts map[[2]string]int
+}
+
+func (c *errorCounter) add(operation, errorType string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.counts == nil {
+		c.counts = make(map[[2]string]int)
+	}
+	c.counts[[2]string{operation, errorType}]++
+}
+
+func (c *errorCounter) snapshot() map[[2]string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return maps.Clone(c.counts)
+}
+
+// escapeLabel escapes a label value of the Prometheus text format
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// PrometheusHandler exposes the tracked data of every registered experiment, along with
+// the errors of the manager, in the Prometheus text exposition format
+func (m *ExperimentManager) PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		experiments := m.GetRegisterExperiments()
+		keys := slices.Sorted(maps.Keys(experiments))
+
+		results := make([]*ExperimentResults, 0, len(keys))
+		for _, key := range keys {
+			result, err := m.GetResults(key)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("cannot read results of experiment `%s`: %v", key, err), http.StatusInternalServerError)
+				return
+			}
+			results = append(results, result)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		buf := bufio.NewWriter(w)
+		defer buf.Flush()
+
+		fmt.Fprintln(buf, "# HELP swole_participants_total Participants enrolled in an alternative of an experiment.")
+		fmt.Fprintln(buf, "# TYPE swole_participants_total counter")
+		for _, result := range results {
+			for _, a := range result.Alternatives {
+				fmt.Fprintf(buf, "swole_participants_total{experiment=\"%s\",alternative=\"%s\"} %d\n", escapeLabel(result.Key), escapeLabel(a.Name), a.Participants)
+			}
+		}
+
+		fmt.Fprintln(buf, "# HELP swole_conversions_total Participants that finished an alternative of an experiment.")
+		fmt.Fprintln(buf, "# TYPE swole_conversions_total counter")
+		for _, result := range results {
+			for _, a := range result.Alternatives {
+				fmt.Fprintf(buf, "swole_conversions_total{experiment=\"%s\",alternative=\"%s\"} %d\n", escapeLabel(result.Key), escapeLabel(a.Name), a.Completions)
+			}
+		}
+
+		fmt.Fprintln(buf, "# HELP swole_exclusions_total Starts of participants that were not enrolled in an experiment, by reason.")
+		fmt.Fprintln(buf, "# TYPE swole_exclusions_total counter")
+		for _, result := range results {
+			for _, reason := range slices.Sorted(maps.Keys(result.Exclusions)) {
+				fmt.Fprintf(buf, "swole_exclusions_total{experiment=\"%s\",reason=\"%s\"} %d\n", escapeLabel(result.Key), escapeLabel(string(reason)), result.Exclusions[reason])
+			}
+		}
+
+		fmt.Fprintln(buf, "# HELP swole_sample_ratio_p_value P-value of the check of the split of the participants against the weights, low values mean the experiment is broken.")
+		fmt.Fprintln(buf, "# TYPE swole_sample_ratio_p_value gauge")
+		for _, result := range results {
+			fmt.Fprintf(buf, "swole_sample_ratio_p_value{experiment=\"%s\"} %g\n", escapeLabel(result.Key), result.SampleRatio.PValue)
+		}
+
+		errorCounts := m.errors.snapshot()
+		fmt.Fprintln(buf, "# HELP swole_errors_total Errors of the experiment operations, including the persistence failures handled by the failure policy.")
+		fmt.Fprintln(buf, "# TYPE swole_errors_total counter")
+		for _, key := range slices.SortedFunc(maps.Keys(errorCounts), func(a, b [2]string) int {
+			return strings.Compare(a[0]+a[1], b[0]+b[1])
+		}) {
+			fmt.Fprintf(buf, "swole_errors_total{operation=\"%s\",type=\"%s\"} %d\n", escapeLabel(key[0]), escapeLabel(key[1]), errorCounts[key])
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package swole
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"net/http"
+)
+
+const (
+	// AssignmentsHeader carries the assignments of the subject between services,
+	// formatted as a W3C baggage list
+	AssignmentsHeader = "Swole-Assignments"
+	// SubjectHeader carries the identity of the subject between services
+	SubjectHeader = "Swole-Subject"
+	// SignatureHeader carries the signature of the subject and the assignments, see VerifyAssignments
+	SignatureHeader = "Swole-Signature"
+)
+
+// Transport injects the subject and the assignments carried by the context of the
+// outgoing requests, so that downstream services honor them instead of re-rolling
+type Transport struct {
+	// Base is the RoundTripper doing the actual request, defaults to http.DefaultTransport
+	Base http.RoundTripper
+	// Secret signs the subject and the assignments, it must match the secret of VerifyAssignments
+	Secret []byte
+}
+
+func NewTransport(base http.RoundTripper) *Transport {
+	return &Transport{Base: base}
+}
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	subject, hasSubject := SubjectFromContext(r.Context())
+	assignments := AssignmentsFromContext(r.Context())
+	if !hasSubject && len(assignments) == 0 {
+		return base.RoundTrip(r)
+	}
+
+	// a RoundTripper must not modify the request it was given
+	r = r.Clone(r.Context())
+	if hasSubject {
+		r.Header.Set(SubjectHeader, subject)
+	}
+	if len(assignments) > 0 {
+		r.Header.Set(AssignmentsHeader, assignments.Encode())
+	}
+	if len(t.Secret) > 0 {
+		r.Header.Set(SignatureHeader, SignPropagation(r.Header.Get(SubjectHeader), r.Header.Get(AssignmentsHeader), t.Secret))
+	}
+
+	return base.RoundTrip(r)
+}
+
+// propagatedAssignment returns the alternative of the experiment the upstream service assigned
+// to the participant. Assignments propagated for another subject are ignored, participants of
+// requests without an identity of their own are taken to be the subject of the upstream service
+func propagatedAssignment(ctx context.Context, experiment Experiment, p participant) (string, bool) {
+	alternative, found := AssignmentsFromContext(ctx)[experiment.Key]
+	if !found || !experiment.hasAlternative(alternative) {
+		return "", false
+	}
+
+	if subject, ok := SubjectFromContext(ctx); ok {
+		if id := p.knownIdentity(); len(id) > 0 && id != subject {
+			return "", false
+		}
+	}
+
+	return alternative, true
+}
+
+// SignPropagation signs the subject and the encoded assignments together, so that neither can
+// be replaced on its own. It is exported for the transports other than HTTP, like swolegrpc
+func SignPropagation(subject, assignments string, secret []byte) string {
+	return base64.RawURLEncoding.EncodeToString(sign(subject+"\n"+assignments, secret))
+}
+
+// VerifyPropagation reports whether the signature is the one of the subject and the encoded
+// assignments made by SignPropagation with the secret
+func VerifyPropagation(subject, assignments, signature string, secret []byte) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(decoded, sign(subject+"\n"+assignments, secret))
+}
+
+// ExtractAssignments is a middleware that places the subject and the assignments of the
+// upstream service in the context of the request. Requests with malformed assignments
+// are served without them. The headers are trusted as they are, so it must only serve
+// requests of internal services, anyone else could choose their own alternatives. Services
+// that can be reached from outside should use VerifyAssignments
+func ExtractAssignments(next http.Handler) http.Handler {
+	return extractAssignments(nil, next)
+}
+
+// VerifyAssignments is like ExtractAssignments but it only honors the subject and the
+// assignments signed with the secret by a Transport, unsigned ones are ignored
+func VerifyAssignments(secret []byte, next http.Handler) http.Handler {
+	return extractAssignments(secret, next)
+}
+
+func extractAssignments(secret []byte, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		if len(secret) > 0 {
+			if !VerifyPropagation(r.Header.Get(SubjectHeader), r.Header.Get(AssignmentsHeader), r.Header.Get(SignatureHeader), secret) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if subject := r.Header.Get(SubjectHeader); len(subject) > 0 {
+			ctx = WithSubject(ctx, subject)
+		}
+
+		if value := r.Header.Get(AssignmentsHeader); len(value) > 0 {
+			assignments, err := ParseAssignments(value)
+			if err == nil {
+				ctx = WithAssignments(ctx, assignments)
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
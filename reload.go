@@ -0,0 +1,86 @@
+package swole
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"time"
+)
+
+// ReloadExperiments replaces the experiments loaded from the ExperimentStore with its
+// current content. Stored experiments override the ones registered in code with the same
+// key, keeping the Allocator of the registered one as it cannot be stored. The payloads of the
+// ones overriding a typed experiment must match its type. When any stored experiment is
+// invalid nothing is replaced and the error is returned
+func (m *ExperimentManager) ReloadExperiments() error {
+	if m.ExperimentStore == nil {
+		return ErrNoExperimentStore
+	}
+
+	experiments, err := m.ExperimentStore.List()
+	if err != nil {
+		m.logReload(0, err)
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := make(RegisteredExperiments, len(experiments))
+	for _, experiment := range experiments {
+		if registered, found := m.registeredExperiments[experiment.Key]; found && experiment.Allocator == nil {
+			experiment.Allocator = registered.Allocator
+		}
+
+		others := maps.Clone(m.registeredExperiments)
+		maps.Copy(others, stored)
+
+		experiment, err = m.prepareExperiment(experiment, others)
+		if check, found := m.payloadChecks[experiment.Key]; found && err == nil {
+			err = check(experiment)
+		}
+		if err != nil {
+			m.logReload(0, err)
+			return err
+		}
+		stored[experiment.Key] = experiment
+	}
+	m.storedExperiments = stored
+
+	m.logReload(len(stored), nil)
+
+	return nil
+}
+
+// WatchExperiments reloads the experiments every interval until the context is done.
+// Failed reloads keep the previous experiments and are logged
+func (m *ExperimentManager) WatchExperiments(ctx context.Context, interval time.Duration) error {
+	if m.ExperimentStore == nil {
+		return ErrNoExperimentStore
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			_ = m.ReloadExperiments()
+		}
+	}
+}
+
+func (m *ExperimentManager) logReload(count int, err error) {
+	if m.Logger == nil {
+		return
+	}
+
+	if err != nil {
+		m.Logger.Error("swole: cannot reload experiments", slog.Any("error", err))
+		return
+	}
+
+	m.Logger.Info("swole: experiments reloaded", slog.Int("swole.experiments", count))
+}
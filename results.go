@@ -0,0 +1,142 @@
+package swole
+
+import "io"
+
+type AlternativeResult struct {
+	Name         string
+	Weight       int
+	Participants int
+	Completions  int
+}
+
+// ConversionRate returns the ratio of participants that completed the experiment
+func (a AlternativeResult) ConversionRate() float64 {
+	if a.Participants == 0 {
+		return 0
+	}
+
+	return float64(a.Completions) / float64(a.Participants)
+}
+
+type ExperimentResults struct {
+	Key          string
+	Participants int
+	Completions  int
+	// Alternatives are in the order they were registered
+	Alternatives []AlternativeResult
+	// Exclusions counts the starts of participants that were not enrolled, keyed by reason. A
+	// participant that is excluded is counted on every start since it is not persisted
+	Exclusions map[ExclusionReason]int
+	// SampleRatio checks that the participants are split according to the weights
+	SampleRatio SampleRatioCheck
+	// Holdouts are the participants of the holdouts of the experiment, named after the holdout.
+	// They were all served the control
+	Holdouts []AlternativeResult
+	// Factors are the main effects of the factors of a multivariate experiment, the
+	// Alternatives are then the combinations
+	Factors []FactorResult
+}
+
+// GetResults returns what was tracked for every alternative of the experiment
+func (m *ExperimentManager) GetResults(key string) (*ExperimentResults, error) {
+	experiment, found := m.getExperiment(key)
+	if !found {
+		return nil, &ExperimentNotFoundError{
+			key:     key,
+			message: "GetResults failed, make sure you called `RegisterExperiment` first",
+		}
+	}
+
+	counts, err := m.TrackingStore.Counts(key)
+	if err != nil {
+		return nil, err
+	}
+
+	exclusions, err := m.TrackingStore.Exclusions(key)
+	if err != nil {
+		return nil, err
+	}
+
+	holdouts, err := m.holdoutResults(experiment)
+	if err != nil {
+		return nil, err
+	}
+
+	results := newExperimentResults(experiment, m.experimentAllocator(experiment), counts, exclusions, m.sampleRatioThreshold())
+	results.Holdouts = holdouts
+
+	return results, nil
+}
+
+// newExperimentResults computes the results, the split of the participants is only checked when
+// the allocator follows the weights
+func newExperimentResults(experiment Experiment, allocator Allocator, counts map[string]AlternativeCounts, exclusions map[ExclusionReason]int, threshold float64) *ExperimentResults {
+	results := &ExperimentResults{
+		Key:          experiment.Key,
+		Alternatives: make([]AlternativeResult, 0, len(experiment.Alternatives)),
+		Exclusions:   exclusions,
+	}
+	for _, a := range experiment.Alternatives {
+		c := counts[a.Name]
+		results.Participants += c.Participants
+		results.Completions += c.Completions
+		results.Alternatives = append(results.Alternatives, AlternativeResult{
+			Name:         a.Name,
+			Weight:       a.Weight,
+			Participants: c.Participants,
+			Completions:  c.Completions,
+		})
+	}
+	if len(experiment.Factors) > 0 {
+		results.Factors = experiment.factorResults(results.Alternatives)
+	}
+
+	if followsWeights(allocator) {
+		results.SampleRatio = checkSampleRatio(results.Alternatives, threshold)
+	} else {
+		results.SampleRatio = SampleRatioCheck{PValue: 1, DegreesOfFreedom: len(results.Alternatives) - 1}
+	}
+
+	return results
+}
+
+// countingEventWriter counts exposures as participants and goals as completions, the held out
+// participants were not enrolled so their events are skipped
+type countingEventWriter map[string]AlternativeCounts
+
+func (c countingEventWriter) Write(event Event) error {
+	if len(event.Holdout) > 0 {
+		return nil
+	}
+
+	counts := c[event.Alternative]
+	switch event.Type {
+	case EventExposure:
+		counts.Participants++
+	case EventGoal:
+		counts.Completions++
+	}
+	c[event.Alternative] = counts
+
+	return nil
+}
+
+func (c countingEventWriter) Flush() error {
+	return nil
+}
+
+// ResultsFromEvents computes the results of the experiment from the events written by a
+// JSONLinesEventSink. Events do not record exclusions so they are left empty, the events of the
+// holdouts are skipped. The split of the participants is checked with DefaultSampleRatioThreshold
+// when the Allocator of the experiment follows the weights
+func ResultsFromEvents(r io.Reader, experiment Experiment) (*ExperimentResults, error) {
+	experiment = experiment.served()
+	counts := make(countingEventWriter)
+
+	_, err := ExportEvents(r, EventFilter{Experiment: experiment.Key}, counts)
+	if err != nil {
+		return nil, err
+	}
+
+	return newExperimentResults(experiment, experiment.Allocator, counts, map[ExclusionReason]int{}, DefaultSampleRatioThreshold), nil
+}
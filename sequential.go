@@ -0,0 +1,112 @@
+package swole
+
+import (
+	"fmt"
+	"math"
+)
+
+// Recommendation tells whether an experiment has collected enough evidence
+type Recommendation string
+
+const (
+	// RecommendContinue means no alternative is significantly different from the control yet
+	RecommendContinue Recommendation = "continue"
+	// RecommendStop means at least one alternative is significantly different from the control
+	RecommendStop Recommendation = "stop"
+)
+
+// SequentialParams configures the mixture sequential probability ratio test (mSPRT)
+type SequentialParams struct {
+	// Alpha is the false positive rate of the experiment, zero means 0.05. It is split
+	// between the alternatives compared with the control
+	Alpha float64
+	// Tau is the standard deviation of the effects the test expects, as a difference of
+	// conversion rates. Tests are the most sensitive to effects of that size, zero means 0.01
+	Tau float64
+}
+
+// SequentialComparison compares an alternative with the control
+type SequentialComparison struct {
+	Name string `json:"name"`
+	// Difference is the conversion rate of the alternative minus the one of the control
+	Difference float64 `json:"difference"`
+	// PValue stays valid no matter how many times the results are looked at
+	PValue      float64 `json:"p_value"`
+	Significant bool    `json:"significant"`
+}
+
+type SequentialResult struct {
+	Key string `json:"key"`
+	// Comparisons are in the order the alternatives were registered, without the control
+	Comparisons    []SequentialComparison `json:"comparisons"`
+	Recommendation Recommendation         `json:"recommendation"`
+}
+
+// SequentialTest compares every alternative with the control, the first alternative, using
+// an mSPRT with a normal mixture. Unlike fixed horizon tests its p-values are always valid so
+// the results can be checked as often as needed and the experiment stopped as soon as the
+// recommendation is RecommendStop.
+//
+// P-values are computed from the current counts. They are valid at any time but can go up
+// as more participants arrive, callers that keep the previous p-values can keep the lowest one
+func (r *ExperimentResults) SequentialTest(params SequentialParams) (*SequentialResult, error) {
+	if params.Alpha == 0 {
+		params.Alpha = 0.05
+	}
+	if params.Tau == 0 {
+		params.Tau = 0.01
+	}
+
+	switch {
+	case params.Alpha <= 0 || params.Alpha >= 1:
+		return nil, fmt.Errorf("%w: alpha must be between 0 and 1", ErrInvalidParameter)
+	case params.Tau < 0:
+		return nil, fmt.Errorf("%w: tau must be positive", ErrInvalidParameter)
+	case len(r.Alternatives) < 2:
+		return nil, fmt.Errorf("%w: at least 2 alternatives are needed", ErrInvalidParameter)
+	}
+
+	result := &SequentialResult{
+		Key:            r.Key,
+		Comparisons:    make([]SequentialComparison, 0, len(r.Alternatives)-1),
+		Recommendation: RecommendContinue,
+	}
+
+	// Bonferroni correction, the test stops when any of the alternatives is significant
+	alpha := params.Alpha / float64(len(r.Alternatives)-1)
+	control := r.Alternatives[0]
+	for _, a := range r.Alternatives[1:] {
+		comparison := SequentialComparison{
+			Name:       a.Name,
+			Difference: a.ConversionRate() - control.ConversionRate(),
+			PValue:     mixturePValue(control, a, params.Tau),
+		}
+		comparison.Significant = comparison.PValue < alpha
+		if comparison.Significant {
+			result.Recommendation = RecommendStop
+		}
+		result.Comparisons = append(result.Comparisons, comparison)
+	}
+
+	return result, nil
+}
+
+// mixturePValue is 1/Λ capped at 1, where Λ is the likelihood ratio of the difference of the
+// conversion rates mixed over a normal prior centered on zero with variance tau²
+func mixturePValue(control, alternative AlternativeResult, tau float64) float64 {
+	if control.Participants == 0 || alternative.Participants == 0 {
+		return 1
+	}
+
+	p1, p2 := control.ConversionRate(), alternative.ConversionRate()
+	variance := p1*(1-p1)/float64(control.Participants) + p2*(1-p2)/float64(alternative.Participants)
+	if variance == 0 {
+		return 1
+	}
+
+	difference := p2 - p1
+	tau2 := tau * tau
+	logRatio := 0.5*math.Log(variance/(variance+tau2)) + difference*difference*tau2/(2*variance*(variance+tau2))
+
+	return math.Min(1, math.Exp(-logRatio))
+}
@@ -0,0 +1,126 @@
+package swole
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// DefaultExperimentsTable is the table of a SQLExperimentStore unless it is set
+const DefaultExperimentsTable = "swole_experiments"
+
+// SQLExperimentStore keeps the experiments in a SQL database, one row per experiment holding
+// its JSON. It works with any database/sql driver, the table can be created with CreateTable
+type SQLExperimentStore struct {
+	DB *sql.DB
+	// Table is the name of the table, it defaults to DefaultExperimentsTable
+	Table string
+	// Placeholder returns the placeholder of the nth argument of a query, starting at 1. It
+	// defaults to `?`, PostgreSQL needs `$n`
+	Placeholder func(n int) string
+}
+
+func NewSQLExperimentStore(db *sql.DB) *SQLExperimentStore {
+	return &SQLExperimentStore{DB: db}
+}
+
+func (s *SQLExperimentStore) table() string {
+	if len(s.Table) == 0 {
+		return DefaultExperimentsTable
+	}
+
+	return s.Table
+}
+
+func (s *SQLExperimentStore) placeholder(n int) string {
+	if s.Placeholder == nil {
+		return "?"
+	}
+
+	return s.Placeholder(n)
+}
+
+// CreateTable creates the table of the experiments if it does not exist
+func (s *SQLExperimentStore) CreateTable() error {
+	_, err := s.DB.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (experiment_key VARCHAR(255) PRIMARY KEY, definition TEXT NOT NULL)", s.table()))
+
+	return err
+}
+
+func (s *SQLExperimentStore) Get(key string) (Experiment, bool, error) {
+	var definition string
+	err := s.DB.QueryRow(fmt.Sprintf("SELECT definition FROM %s WHERE experiment_key = %s", s.table(), s.placeholder(1)), key).Scan(&definition)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Experiment{}, false, nil
+	}
+	if err != nil {
+		return Experiment{}, false, err
+	}
+
+	var experiment Experiment
+	err = json.Unmarshal([]byte(definition), &experiment)
+	if err != nil {
+		return Experiment{}, false, err
+	}
+
+	return experiment, true, nil
+}
+
+// Set replaces the experiment in a transaction, so that readers never miss it
+func (s *SQLExperimentStore) Set(key string, exp Experiment) error {
+	exp.Key = key
+	definition, err := json.Marshal(exp)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE experiment_key = %s", s.table(), s.placeholder(1)), key)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (experiment_key, definition) VALUES (%s, %s)", s.table(), s.placeholder(1), s.placeholder(2)), key, string(definition))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLExperimentStore) Delete(key string) error {
+	_, err := s.DB.Exec(fmt.Sprintf("DELETE FROM %s WHERE experiment_key = %s", s.table(), s.placeholder(1)), key)
+
+	return err
+}
+
+func (s *SQLExperimentStore) List() ([]Experiment, error) {
+	rows, err := s.DB.Query(fmt.Sprintf("SELECT definition FROM %s ORDER BY experiment_key", s.table()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var experiments []Experiment
+	for rows.Next() {
+		var definition string
+		err = rows.Scan(&definition)
+		if err != nil {
+			return nil, err
+		}
+
+		var experiment Experiment
+		err = json.Unmarshal([]byte(definition), &experiment)
+		if err != nil {
+			return nil, err
+		}
+		experiments = append(experiments, experiment)
+	}
+
+	return experiments, rows.Err()
+}
@@ -0,0 +1,265 @@
+package swole
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultCountsTable is the table of the counters of a SQLTrackingStore unless it is set
+	DefaultCountsTable = "swole_counts"
+	// DefaultValuesTable is the table of the values of a SQLTrackingStore unless it is set
+	DefaultValuesTable = "swole_values"
+)
+
+// the counters of an experiment, the ones of an alternative or a reason are followed by it
+const (
+	participantsCounter = "participants"
+	completionsCounter  = "completions"
+	exclusionsCounter   = "exclusions"
+)
+
+// SQLTrackingStore keeps the counts in a SQL database, so that it can be shared by many
+// instances of the application. Every count is a row that is updated in place, the cap of the
+// participants is checked by the update itself so it holds across instances. Every value is
+// kept. It works with any database/sql driver, the tables can be created with CreateTables
+type SQLTrackingStore struct {
+	DB *sql.DB
+	// CountsTable is the name of the table of the counters, it defaults to DefaultCountsTable
+	CountsTable string
+	// ValuesTable is the name of the table of the values, it defaults to DefaultValuesTable
+	ValuesTable string
+	// Placeholder returns the placeholder of the nth argument of a query, starting at 1. It
+	// defaults to `?`, PostgreSQL needs `$n`
+	Placeholder func(n int) string
+}
+
+func NewSQLTrackingStore(db *sql.DB) *SQLTrackingStore {
+	return &SQLTrackingStore{DB: db}
+}
+
+func (s *SQLTrackingStore) countsTable() string {
+	if len(s.CountsTable) == 0 {
+		return DefaultCountsTable
+	}
+
+	return s.CountsTable
+}
+
+func (s *SQLTrackingStore) valuesTable() string {
+	if len(s.ValuesTable) == 0 {
+		return DefaultValuesTable
+	}
+
+	return s.ValuesTable
+}
+
+func (s *SQLTrackingStore) placeholder(n int) string {
+	if s.Placeholder == nil {
+		return "?"
+	}
+
+	return s.Placeholder(n)
+}
+
+// CreateTables creates the tables of the counters and the values if they do not exist
+func (s *SQLTrackingStore) CreateTables() error {
+	_, err := s.DB.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (experiment_key VARCHAR(255) NOT NULL, counter VARCHAR(255) NOT NULL, amount BIGINT NOT NULL, PRIMARY KEY (experiment_key, counter))", s.countsTable()))
+	if err != nil {
+		return err
+	}
+
+	_, err = s.DB.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (experiment_key VARCHAR(255) NOT NULL, goal VARCHAR(255) NOT NULL, alternative VARCHAR(255) NOT NULL, amount DOUBLE PRECISION NOT NULL)", s.valuesTable()))
+
+	return err
+}
+
+// update adds delta to the counter when it stays within the limit, a negative delta never
+// takes it below zero
+func (s *SQLTrackingStore) update(key, counter string, delta, limit int) (bool, error) {
+	query := fmt.Sprintf("UPDATE %s SET amount = amount + %d WHERE experiment_key = %s AND counter = %s", s.countsTable(), delta, s.placeholder(1), s.placeholder(2))
+	args := []any{key, counter}
+	switch {
+	case delta < 0:
+		query += " AND amount > 0"
+	case limit > 0:
+		query += fmt.Sprintf(" AND amount < %s", s.placeholder(3))
+		args = append(args, limit)
+	}
+
+	result, err := s.DB.Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+// increment adds one to the counter unless it has reached the limit. The row of the counter
+// is created the first time it is incremented
+func (s *SQLTrackingStore) increment(key, counter string, limit int) (bool, error) {
+	incremented, err := s.update(key, counter, 1, limit)
+	if err != nil || incremented {
+		return incremented, err
+	}
+
+	// an error means that the row exists already, either it reached the limit or another
+	// instance created it in the meantime
+	_, insertErr := s.DB.Exec(fmt.Sprintf("INSERT INTO %s (experiment_key, counter, amount) VALUES (%s, %s, 0)", s.countsTable(), s.placeholder(1), s.placeholder(2)), key, counter)
+
+	incremented, err = s.update(key, counter, 1, limit)
+	if err != nil {
+		return false, err
+	}
+	if !incremented && limit <= 0 {
+		return false, insertErr
+	}
+
+	return incremented, nil
+}
+
+func (s *SQLTrackingStore) decrement(key, counter string) error {
+	_, err := s.update(key, counter, -1, 0)
+
+	return err
+}
+
+// AddParticipant counts the participants of the experiment and the ones of the alternative
+// separately, the limit is checked against the first
+func (s *SQLTrackingStore) AddParticipant(key, alternative string, limit int) (bool, error) {
+	added, err := s.increment(key, participantsCounter, limit)
+	if err != nil || !added {
+		return false, err
+	}
+
+	_, err = s.increment(key, participantsCounter+"/"+alternative, 0)
+	if err != nil {
+		// give back the slot, the participant was not recorded
+		return false, errors.Join(err, s.decrement(key, participantsCounter))
+	}
+
+	return true, nil
+}
+
+func (s *SQLTrackingStore) RemoveParticipant(key, alternative string) error {
+	err := s.decrement(key, participantsCounter+"/"+alternative)
+	if err != nil {
+		return err
+	}
+
+	return s.decrement(key, participantsCounter)
+}
+
+func (s *SQLTrackingStore) AddCompletion(key, alternative string) error {
+	_, err := s.increment(key, completionsCounter+"/"+alternative, 0)
+
+	return err
+}
+
+func (s *SQLTrackingStore) AddValue(key, alternative, goal string, value float64) error {
+	_, err := s.DB.Exec(fmt.Sprintf("INSERT INTO %s (experiment_key, goal, alternative, amount) VALUES (%s, %s, %s, %s)", s.valuesTable(), s.placeholder(1), s.placeholder(2), s.placeholder(3), s.placeholder(4)), key, goal, alternative, value)
+
+	return err
+}
+
+func (s *SQLTrackingStore) AddExclusion(key string, reason ExclusionReason) error {
+	_, err := s.increment(key, exclusionsCounter+"/"+string(reason), 0)
+
+	return err
+}
+
+// counters returns the counters of the experiment with the prefix keyed by what follows it
+func (s *SQLTrackingStore) counters(key, prefix string) (map[string]int, error) {
+	rows, err := s.DB.Query(fmt.Sprintf("SELECT counter, amount FROM %s WHERE experiment_key = %s", s.countsTable(), s.placeholder(1)), key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counters := make(map[string]int)
+	for rows.Next() {
+		var (
+			counter string
+			amount  int
+		)
+		err = rows.Scan(&counter, &amount)
+		if err != nil {
+			return nil, err
+		}
+
+		name, found := strings.CutPrefix(counter, prefix+"/")
+		if found {
+			counters[name] = amount
+		}
+	}
+
+	return counters, rows.Err()
+}
+
+func (s *SQLTrackingStore) Counts(key string) (map[string]AlternativeCounts, error) {
+	participants, err := s.counters(key, participantsCounter)
+	if err != nil {
+		return nil, err
+	}
+	completions, err := s.counters(key, completionsCounter)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]AlternativeCounts, len(participants))
+	for alternative, n := range participants {
+		c := counts[alternative]
+		c.Participants = n
+		counts[alternative] = c
+	}
+	for alternative, n := range completions {
+		c := counts[alternative]
+		c.Completions = n
+		counts[alternative] = c
+	}
+
+	return counts, nil
+}
+
+func (s *SQLTrackingStore) Exclusions(key string) (map[ExclusionReason]int, error) {
+	counters, err := s.counters(key, exclusionsCounter)
+	if err != nil {
+		return nil, err
+	}
+
+	exclusions := make(map[ExclusionReason]int, len(counters))
+	for reason, n := range counters {
+		exclusions[ExclusionReason(reason)] = n
+	}
+
+	return exclusions, nil
+}
+
+func (s *SQLTrackingStore) Values(key, goal string) (map[string][]float64, error) {
+	rows, err := s.DB.Query(fmt.Sprintf("SELECT alternative, amount FROM %s WHERE experiment_key = %s AND goal = %s", s.valuesTable(), s.placeholder(1), s.placeholder(2)), key, goal)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make(map[string][]float64)
+	for rows.Next() {
+		var (
+			alternative string
+			value       float64
+		)
+		err = rows.Scan(&alternative, &value)
+		if err != nil {
+			return nil, err
+		}
+		values[alternative] = append(values[alternative], value)
+	}
+
+	return values, rows.Err()
+}
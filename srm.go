@@ -0,0 +1,245 @@
+package swole
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync"
+)
+
+// DefaultSampleRatioThreshold is the p-value below which the split of the participants is
+// considered broken. It is low on purpose since the check runs many times during an experiment
+const DefaultSampleRatioThreshold = 0.001
+
+// DefaultSampleRatioCheckInterval is the number of new participants between automatic checks
+const DefaultSampleRatioCheckInterval = 1000
+
+// SampleRatioCheck is a chi-squared goodness of fit test of the participants of every
+// alternative against the weights of the alternatives. A mismatch usually means that the
+// experiment is broken, for example a variant that crashes before it is started
+type SampleRatioCheck struct {
+	ChiSquared       float64 `json:"chi_squared"`
+	DegreesOfFreedom int     `json:"degrees_of_freedom"`
+	PValue           float64 `json:"p_value"`
+	Mismatch         bool    `json:"mismatch"`
+}
+
+// checkSampleRatio tests the participants against the weights. The test is not reliable with
+// less than 5 expected participants per alternative so it passes until then
+func checkSampleRatio(alternatives []AlternativeResult, threshold float64) SampleRatioCheck {
+	check := SampleRatioCheck{PValue: 1, DegreesOfFreedom: len(alternatives) - 1}
+
+	participants, weights := 0, 0
+	for _, a := range alternatives {
+		participants += a.Participants
+		weights += a.Weight
+	}
+	if participants == 0 || weights == 0 || check.DegreesOfFreedom < 1 {
+		return check
+	}
+
+	for _, a := range alternatives {
+		expected := float64(participants) * float64(a.Weight) / float64(weights)
+		if expected < 5 {
+			return SampleRatioCheck{PValue: 1, DegreesOfFreedom: check.DegreesOfFreedom}
+		}
+		difference := float64(a.Participants) - expected
+		check.ChiSquared += difference * difference / expected
+	}
+
+	check.PValue = regularizedUpperGamma(float64(check.DegreesOfFreedom)/2, check.ChiSquared/2)
+	check.Mismatch = check.PValue < threshold
+
+	return check
+}
+
+func (m *ExperimentManager) sampleRatioThreshold() float64 {
+	if m.SampleRatioThreshold == 0 {
+		return DefaultSampleRatioThreshold
+	}
+
+	return m.SampleRatioThreshold
+}
+
+// CheckSampleRatio checks the split of the participants of the experiment and alerts the Logger
+// and the EventSink when it does not match the weights. Checks also run automatically, see
+// SampleRatioCheckInterval. Changing the weights with a new version of the experiment skews
+// the split of the participants, in that case mismatches are expected
+func (m *ExperimentManager) CheckSampleRatio(ctx context.Context, key string) (*SampleRatioCheck, error) {
+	results, err := m.GetResults(key)
+	if err != nil {
+		return nil, err
+	}
+
+	check := results.SampleRatio
+	if m.sampleRatio.alert(key, check.Mismatch) {
+		m.alertSampleRatio(ctx, key, check)
+	}
+
+	return &check, nil
+}
+
+func (m *ExperimentManager) alertSampleRatio(ctx context.Context, key string, check SampleRatioCheck) {
+	if m.Logger != nil {
+		m.Logger.LogAttrs(ctx, slog.LevelWarn, "swole: sample ratio mismatch",
+			slog.String(AttributeExperiment, key),
+			slog.Float64("swole.chi_squared", check.ChiSquared),
+			slog.Float64("swole.p_value", check.PValue),
+		)
+	}
+
+	m.emit(ctx, Event{
+		Type:       EventSampleRatioMismatch,
+		Time:       m.Now(),
+		Experiment: key,
+		Value:      check.PValue,
+	})
+}
+
+// watchSampleRatio checks the split of the participants every SampleRatioCheckInterval new participants.
+// The check reads the results of the experiment, so it runs in the background instead of delaying
+// the participant, at most one at a time per experiment
+func (m *ExperimentManager) watchSampleRatio(ctx context.Context, key string, response *StartExperimentResponse, err error) {
+	if err != nil || !response.DidStartFirstTime || response.Propagated || m.SampleRatioCheckInterval < 0 {
+		return
+	}
+
+	interval := m.SampleRatioCheckInterval
+	if interval == 0 {
+		interval = DefaultSampleRatioCheckInterval
+	}
+
+	if m.sampleRatio.enroll(key)%interval != 0 || !m.sampleRatio.startCheck(key) {
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	m.sampleRatio.checks.Add(1)
+	go func() {
+		defer m.sampleRatio.checks.Done()
+		defer m.sampleRatio.endCheck(key)
+
+		// failures to read the results are not the participant's problem, the next check retries
+		_, _ = m.CheckSampleRatio(ctx, key)
+	}()
+}
+
+// Wait waits for the checks running in the background, like the automatic checks of the split
+// of the participants. Call it before the application exits so that their alerts are not lost
+func (m *ExperimentManager) Wait() {
+	m.sampleRatio.checks.Wait()
+}
+
+// sampleRatioState counts the participants enrolled by this instance and remembers which
+// experiments already alerted, so that a mismatch alerts once until it is resolved
+type sampleRatioState struct {
+	mu       sync.Mutex
+	enrolled map[string]int
+	alerted  map[string]bool
+	// checking holds the experiments with a check running in the background
+	checking map[string]bool
+	checks   sync.WaitGroup
+}
+
+// startCheck reports whether a background check of the experiment can start
+func (s *sampleRatioState) startCheck(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.checking == nil {
+		s.checking = make(map[string]bool)
+	}
+	if s.checking[key] {
+		return false
+	}
+	s.checking[key] = true
+
+	return true
+}
+
+func (s *sampleRatioState) endCheck(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.checking, key)
+}
+
+func (s *sampleRatioState) enroll(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.enrolled == nil {
+		s.enrolled = make(map[string]int)
+	}
+	s.enrolled[key]++
+
+	return s.enrolled[key]
+}
+
+// alert records the outcome of a check and reports whether it is a new mismatch
+func (s *sampleRatioState) alert(key string, mismatch bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.alerted == nil {
+		s.alerted = make(map[string]bool)
+	}
+	alerted := s.alerted[key]
+	s.alerted[key] = mismatch
+
+	return mismatch && !alerted
+}
+
+// regularizedUpperGamma evaluates Q(a, x), the chi-squared survival function with 2a degrees
+// of freedom at 2x, using the series or the continued fraction of Numerical Recipes
+func regularizedUpperGamma(a, x float64) float64 {
+	const (
+		iterations = 300
+		epsilon    = 1e-14
+		tiny       = 1e-300
+	)
+
+	if x <= 0 {
+		return 1
+	}
+
+	lgammaA, _ := math.Lgamma(a)
+	front := math.Exp(-x + a*math.Log(x) - lgammaA)
+
+	if x < a+1 {
+		sum, term := 1/a, 1/a
+		for n := 1; n <= iterations; n++ {
+			term *= x / (a + float64(n))
+			sum += term
+			if math.Abs(term) < math.Abs(sum)*epsilon {
+				break
+			}
+		}
+		return max(0, 1-front*sum)
+	}
+
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for n := 1; n <= iterations; n++ {
+		an := -float64(n) * (float64(n) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < epsilon {
+			break
+		}
+	}
+
+	return front * h
+}
@@ -0,0 +1,129 @@
+package swole
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+var ErrInvalidParameter = errors.New("invalid parameter")
+
+// SampleSizeParams describes an experiment before it is launched
+type SampleSizeParams struct {
+	// BaselineRate is the expected conversion rate of the control, between 0 and 1
+	BaselineRate float64
+	// MinimumDetectableEffect is the smallest lift worth detecting, relative to the
+	// baseline. 0.1 detects a change from a 5% to a 5.5% conversion rate
+	MinimumDetectableEffect float64
+	// Alpha is the significance level of the two sided test, zero means 0.05
+	Alpha float64
+	// Power is the probability to detect the effect when it exists, zero means 0.8
+	Power float64
+	// Alternatives are the alternatives of the experiment, the first one is the control
+	Alternatives Alternatives
+	// TrafficAllocation is the share of the traffic that enters the experiment, zero means all of it
+	TrafficAllocation float64
+	// DailyTraffic is the number of visitors per day, the duration is not estimated when it is zero
+	DailyTraffic int
+}
+
+type AlternativeSampleSize struct {
+	Name       string `json:"name"`
+	Weight     int    `json:"weight"`
+	SampleSize int    `json:"sample_size"`
+}
+
+type SampleSizeEstimate struct {
+	// Alternatives are the participants needed by each alternative, in the order they were given
+	Alternatives []AlternativeSampleSize `json:"alternatives"`
+	// SampleSize is the number of participants needed in total
+	SampleSize int `json:"sample_size"`
+	// Days is how long the experiment must run, zero when DailyTraffic is not set
+	Days int `json:"days,omitempty"`
+}
+
+// SampleSize returns the participants each alternative needs so that comparing it with the
+// control detects the minimum effect. Alternatives share the traffic according to their weights,
+// so an alternative with a small weight makes the whole experiment run longer. The comparisons
+// are not corrected for multiple alternatives, lower Alpha to do so
+func SampleSize(params SampleSizeParams) (*SampleSizeEstimate, error) {
+	if params.Alpha == 0 {
+		params.Alpha = 0.05
+	}
+	if params.Power == 0 {
+		params.Power = 0.8
+	}
+	if params.TrafficAllocation == 0 {
+		params.TrafficAllocation = 1
+	}
+
+	baseline := params.BaselineRate
+	target := baseline * (1 + params.MinimumDetectableEffect)
+
+	switch {
+	case baseline <= 0 || baseline >= 1:
+		return nil, fmt.Errorf("%w: baseline rate must be between 0 and 1", ErrInvalidParameter)
+	case params.MinimumDetectableEffect == 0 || target <= 0 || target >= 1:
+		return nil, fmt.Errorf("%w: minimum detectable effect must keep the rate between 0 and 1", ErrInvalidParameter)
+	case params.Alpha <= 0 || params.Alpha >= 1:
+		return nil, fmt.Errorf("%w: alpha must be between 0 and 1", ErrInvalidParameter)
+	case params.Power <= 0 || params.Power >= 1:
+		return nil, fmt.Errorf("%w: power must be between 0 and 1", ErrInvalidParameter)
+	case params.TrafficAllocation < 0 || params.TrafficAllocation > 1:
+		return nil, fmt.Errorf("%w: traffic allocation must be between 0 and 1", ErrInvalidParameter)
+	case params.DailyTraffic < 0:
+		return nil, fmt.Errorf("%w: daily traffic must be positive", ErrInvalidParameter)
+	case len(params.Alternatives) < 2:
+		return nil, fmt.Errorf("%w: at least 2 alternatives are needed", ErrInvalidParameter)
+	}
+
+	weights := make([]float64, len(params.Alternatives))
+	total := 0.0
+	for i, a := range params.Alternatives {
+		if a.Weight < 0 {
+			return nil, fmt.Errorf("%w: weights must be positive", ErrInvalidParameter)
+		}
+		// like registered experiments a zero weight counts as 1
+		weights[i] = float64(max(a.Weight, 1))
+		total += weights[i]
+	}
+
+	z := normalQuantile(1-params.Alpha/2) + normalQuantile(params.Power)
+	delta := target - baseline
+	control := weights[0] / total
+
+	// the variance of the difference between an alternative with share s and the control is
+	// p1(1-p1)/(N*control) + p2(1-p2)/(N*s), the experiment needs the N that makes the
+	// hardest comparison significant
+	participants := 0.0
+	for _, w := range weights[1:] {
+		share := w / total
+		n := z * z * (baseline*(1-baseline)/control + target*(1-target)/share) / (delta * delta)
+		participants = max(participants, n)
+	}
+
+	estimate := &SampleSizeEstimate{
+		Alternatives: make([]AlternativeSampleSize, 0, len(params.Alternatives)),
+	}
+	for i, a := range params.Alternatives {
+		n := int(math.Ceil(participants * weights[i] / total))
+		estimate.SampleSize += n
+		estimate.Alternatives = append(estimate.Alternatives, AlternativeSampleSize{
+			Name:       a.Name,
+			Weight:     int(weights[i]),
+			SampleSize: n,
+		})
+	}
+
+	if params.DailyTraffic > 0 {
+		daily := float64(params.DailyTraffic) * params.TrafficAllocation
+		estimate.Days = int(math.Ceil(float64(estimate.SampleSize) / daily))
+	}
+
+	return estimate, nil
+}
+
+// normalQuantile is the inverse of the standard normal cumulative distribution
+func normalQuantile(p float64) float64 {
+	return math.Sqrt2 * math.Erfinv(2*p-1)
+}
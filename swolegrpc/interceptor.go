@@ -0,0 +1,191 @@
+// Package swolegrpc assigns experiments to the subjects of gRPC calls and propagates
+// the assignments to the downstream services through the call metadata
+package swolegrpc
+
+import (
+	"context"
+
+	"github.com/antonisgkamitsios/swole"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// SubjectMetadataKey is the default metadata key carrying the identity of the subject
+	SubjectMetadataKey = "swole-subject"
+	// AssignmentsMetadataKey is the metadata key carrying the encoded assignments
+	AssignmentsMetadataKey = "swole-assignments"
+	// SignatureMetadataKey is the metadata key carrying the signature of the subject and the assignments
+	SignatureMetadataKey = "swole-signature"
+)
+
+type Config struct {
+	Manager *swole.ExperimentManager
+	// Experiments are the keys of the experiments started on every call
+	Experiments []string
+	// SubjectKey is the incoming metadata key carrying the subject, defaults to SubjectMetadataKey
+	SubjectKey string
+	// Subject resolves the subject of the call, when set SubjectKey is not used
+	Subject func(ctx context.Context, md metadata.MD) (string, error)
+	// Secret verifies the assignments of the upstream service, it must match the secret of its
+	// client interceptors. When it is set unsigned assignments are ignored, otherwise they are
+	// trusted as they are so the server must only be reached by internal services
+	Secret []byte
+}
+
+// first returns the first value of the key, or an empty string
+func first(md metadata.MD, key string) string {
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+func (c Config) subject(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if c.Subject != nil {
+		return c.Subject(ctx, md)
+	}
+
+	key := c.SubjectKey
+	if len(key) == 0 {
+		key = SubjectMetadataKey
+	}
+
+	return first(md, key), nil
+}
+
+// upstreamAssignments returns the assignments of the upstream service, nil when they are
+// malformed or their signature does not match the secret
+func (c Config) upstreamAssignments(md metadata.MD) swole.Assignments {
+	encoded := first(md, AssignmentsMetadataKey)
+	if len(encoded) == 0 {
+		return nil
+	}
+
+	if len(c.Secret) > 0 && !swole.VerifyPropagation(first(md, SubjectMetadataKey), encoded, first(md, SignatureMetadataKey), c.Secret) {
+		return nil
+	}
+
+	assignments, err := swole.ParseAssignments(encoded)
+	if err != nil {
+		return nil
+	}
+
+	return assignments
+}
+
+// assign starts the configured experiments for the subject of the call and returns a context
+// carrying the subject and the assignments. Calls without a subject are not assigned and
+// the assignments of the upstream service are honored, unless they are malformed or unsigned
+// when a Secret is set. Only the
+// experiments the subject is enrolled in are propagated, the downstream services decide on
+// their own for the excluded ones
+func (c Config) assign(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if upstream := c.upstreamAssignments(md); upstream != nil {
+		ctx = swole.WithAssignments(ctx, upstream)
+	}
+
+	subject, err := c.subject(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "swole: cannot resolve subject: %v", err)
+	}
+	if len(subject) == 0 {
+		return ctx, nil
+	}
+
+	assignments := make(swole.Assignments, len(c.Experiments))
+	for _, key := range c.Experiments {
+		response, err := c.Manager.Start(ctx, subject, key)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "swole: cannot start experiment `%s`: %v", key, err)
+		}
+		if response.DidStart {
+			assignments[key] = response.Alternative
+		}
+	}
+
+	ctx = swole.WithSubject(ctx, subject)
+
+	return swole.WithAssignments(ctx, assignments), nil
+}
+
+// UnaryServerInterceptor starts the configured experiments before the handler runs
+func UnaryServerInterceptor(c Config) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := c.assign(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// serverStream overrides the context of the wrapped stream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamServerInterceptor starts the configured experiments before the handler runs
+func StreamServerInterceptor(c Config) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := c.assign(ss.Context())
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type ClientConfig struct {
+	// Secret signs the subject and the assignments, it must match the Secret of the downstream service
+	Secret []byte
+}
+
+// outgoingContext appends the subject and the assignments carried by the context to the outgoing metadata
+func (c ClientConfig) outgoingContext(ctx context.Context) context.Context {
+	subject, hasSubject := swole.SubjectFromContext(ctx)
+	if hasSubject {
+		ctx = metadata.AppendToOutgoingContext(ctx, SubjectMetadataKey, subject)
+	}
+
+	assignments := swole.AssignmentsFromContext(ctx)
+	if len(assignments) == 0 {
+		return ctx
+	}
+
+	encoded := assignments.Encode()
+	ctx = metadata.AppendToOutgoingContext(ctx, AssignmentsMetadataKey, encoded)
+	if len(c.Secret) > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, SignatureMetadataKey, swole.SignPropagation(subject, encoded, c.Secret))
+	}
+
+	return ctx
+}
+
+// UnaryClientInterceptor propagates the subject and the assignments to the downstream service
+func UnaryClientInterceptor(c ClientConfig) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(c.outgoingContext(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor propagates the subject and the assignments to the downstream service
+func StreamClientInterceptor(c ClientConfig) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(c.outgoingContext(ctx), desc, cc, method, opts...)
+	}
+}
@@ -0,0 +1,217 @@
+package swolegrpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/antonisgkamitsios/swole"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestUnaryInterceptors(t *testing.T) {
+	manager := swole.NewExperimentManager()
+	manager.RegisterExperiment(swole.Experiment{
+		Key:          "experiment_key",
+		Alternatives: swole.Alternatives{{Name: "control"}, {Name: "variant"}},
+	})
+	manager.RegisterExperiment(swole.Experiment{
+		Key:          "paused_key",
+		Alternatives: swole.Alternatives{{Name: "control"}, {Name: "variant"}},
+		Paused:       true,
+	})
+
+	server := UnaryServerInterceptor(Config{
+		Manager:     manager,
+		Experiments: []string{"experiment_key", "paused_key"},
+	})
+	client := UnaryClientInterceptor(ClientConfig{})
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(SubjectMetadataKey, "user_1"))
+
+	var outgoing metadata.MD
+	_, err := server(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req any) (any, error) {
+		assignments := swole.AssignmentsFromContext(ctx)
+		alternative := assignments["experiment_key"]
+		if alternative != "control" && alternative != "variant" {
+			t.Errorf("expected alternative to be control or variant but got: %s", alternative)
+		}
+
+		// a downstream call made by the handler
+		return nil, client(ctx, "/downstream", nil, nil, nil, func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			outgoing, _ = metadata.FromOutgoingContext(ctx)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+
+	if subject := outgoing.Get(SubjectMetadataKey); len(subject) != 1 || subject[0] != "user_1" {
+		t.Errorf("expected subject to be propagated but got: %v", subject)
+	}
+
+	encoded := outgoing.Get(AssignmentsMetadataKey)
+	if len(encoded) != 1 {
+		t.Fatalf("expected assignments to be propagated but got: %v", encoded)
+	}
+	assignments, err := swole.ParseAssignments(encoded[0])
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+
+	response, err := manager.Start(context.Background(), "user_1", "experiment_key")
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if assignments["experiment_key"] != response.Alternative {
+		t.Errorf("expected propagated alternative to be %s but got: %s", response.Alternative, assignments["experiment_key"])
+	}
+	if _, found := assignments["paused_key"]; found {
+		t.Errorf("expected the excluded assignment not to be propagated but got: %v", assignments)
+	}
+}
+
+// controlAllocator assigns every new participant the control, so that the assignments of
+// the upstream service stand out
+type controlAllocator struct{}
+
+func (controlAllocator) Allocate(ctx context.Context, allocation swole.AllocationContext) string {
+	return "control"
+}
+
+// testServerStream is a server stream of which only the context is used
+type testServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStreamInterceptors(t *testing.T) {
+	manager := swole.NewExperimentManager()
+	manager.Allocator = controlAllocator{}
+	manager.RegisterExperiment(swole.Experiment{
+		Key:          "experiment_key",
+		Alternatives: swole.Alternatives{{Name: "control"}, {Name: "variant"}},
+	})
+
+	server := StreamServerInterceptor(Config{Manager: manager, Experiments: []string{"experiment_key"}})
+	client := StreamClientInterceptor(ClientConfig{})
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(SubjectMetadataKey, "user_1"))
+
+	var outgoing metadata.MD
+	err := server(nil, &testServerStream{ctx: ctx}, &grpc.StreamServerInfo{}, func(srv any, stream grpc.ServerStream) error {
+		subject, _ := swole.SubjectFromContext(stream.Context())
+		if subject != "user_1" {
+			t.Errorf("expected the subject of the stream to be user_1 but got: %s", subject)
+		}
+
+		_, err := client(stream.Context(), &grpc.StreamDesc{}, nil, "/downstream", func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			outgoing, _ = metadata.FromOutgoingContext(ctx)
+			return nil, nil
+		})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+
+	if assignments := outgoing.Get(AssignmentsMetadataKey); len(assignments) != 1 || assignments[0] != "experiment_key=control" {
+		t.Errorf("expected the assignment of the stream to be propagated but got: %v", assignments)
+	}
+	if signature := outgoing.Get(SignatureMetadataKey); len(signature) > 0 {
+		t.Errorf("expected the assignments not to be signed without a secret but got: %v", signature)
+	}
+}
+
+func TestUpstreamAssignments(t *testing.T) {
+	secret := []byte("secret")
+
+	// the metadata an upstream service sends with its client interceptor
+	upstream := func(t *testing.T, clientSecret []byte, subject string) metadata.MD {
+		ctx := swole.WithAssignments(swole.WithSubject(context.Background(), subject), swole.Assignments{"experiment_key": "variant"})
+
+		var md metadata.MD
+		err := UnaryClientInterceptor(ClientConfig{Secret: clientSecret})(ctx, "/upstream", nil, nil, nil, func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			md, _ = metadata.FromOutgoingContext(ctx)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("expected not to error but got: %v", err)
+		}
+
+		return md
+	}
+
+	tests := []struct {
+		name            string
+		md              func(t *testing.T) metadata.MD
+		secret          []byte
+		wantAlternative string
+	}{
+		{
+			name:            "Unsigned without a secret",
+			md:              func(t *testing.T) metadata.MD { return upstream(t, nil, "user_1") },
+			wantAlternative: "variant",
+		},
+		{
+			name:            "Signed with the secret",
+			md:              func(t *testing.T) metadata.MD { return upstream(t, secret, "user_1") },
+			secret:          secret,
+			wantAlternative: "variant",
+		},
+		{
+			name:            "Unsigned with a secret",
+			md:              func(t *testing.T) metadata.MD { return upstream(t, nil, "user_1") },
+			secret:          secret,
+			wantAlternative: "control",
+		},
+		{
+			name:            "Signed with another secret",
+			md:              func(t *testing.T) metadata.MD { return upstream(t, []byte("other"), "user_1") },
+			secret:          secret,
+			wantAlternative: "control",
+		},
+		{
+			name: "Signature of another subject",
+			md: func(t *testing.T) metadata.MD {
+				md := upstream(t, secret, "user_2")
+				md.Set(SubjectMetadataKey, "user_1")
+				return md
+			},
+			secret:          secret,
+			wantAlternative: "control",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := swole.NewExperimentManager()
+			manager.Allocator = controlAllocator{}
+			manager.RegisterExperiment(swole.Experiment{
+				Key:          "experiment_key",
+				Alternatives: swole.Alternatives{{Name: "control"}, {Name: "variant"}},
+			})
+
+			server := UnaryServerInterceptor(Config{Manager: manager, Experiments: []string{"experiment_key"}, Secret: tt.secret})
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md(t))
+
+			var alternative string
+			_, err := server(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req any) (any, error) {
+				alternative = swole.AssignmentsFromContext(ctx)["experiment_key"]
+				return nil, nil
+			})
+			if err != nil {
+				t.Fatalf("expected not to error but got: %v", err)
+			}
+
+			if alternative != tt.wantAlternative {
+				t.Errorf("expected alternative to be %s but got: %s", tt.wantAlternative, alternative)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Package swoleotel reports what the swole ExperimentManager does to OpenTelemetry,
+// experiments are added to the active span and counted with a meter
+package swoleotel
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antonisgkamitsios/swole"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Instrumentation implements swole.Instrumentation, the instruments are created
+// the first time they are used
+type Instrumentation struct {
+	meter metric.Meter
+
+	mu         sync.Mutex
+	counters   map[string]metric.Int64Counter
+	histograms map[string]metric.Float64Histogram
+}
+
+var _ swole.Instrumentation = (*Instrumentation)(nil)
+
+func New(meter metric.Meter) *Instrumentation {
+	return &Instrumentation{
+		meter:      meter,
+		counters:   make(map[string]metric.Int64Counter),
+		histograms: make(map[string]metric.Float64Histogram),
+	}
+}
+
+func toAttributes(attributes []swole.Attribute) []attribute.KeyValue {
+	kvs := make([]attribute.KeyValue, 0, len(attributes))
+	for _, a := range attributes {
+		kvs = append(kvs, attribute.String(a.Key, a.Value))
+	}
+
+	return kvs
+}
+
+func (i *Instrumentation) counter(name string) (metric.Int64Counter, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if counter, found := i.counters[name]; found {
+		return counter, nil
+	}
+
+	counter, err := i.meter.Int64Counter(name)
+	if err != nil {
+		return nil, err
+	}
+	i.counters[name] = counter
+
+	return counter, nil
+}
+
+func (i *Instrumentation) histogram(name string) (metric.Float64Histogram, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if histogram, found := i.histograms[name]; found {
+		return histogram, nil
+	}
+
+	histogram, err := i.meter.Float64Histogram(name, metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+	i.histograms[name] = histogram
+
+	return histogram, nil
+}
+
+// Annotate keys the attributes by experiment, since a span can take part in many of them.
+// swole.experiment.<key> is the alternative and the other attributes follow it, like
+// swole.experiment.<key>.first_time
+func (i *Instrumentation) Annotate(ctx context.Context, attributes ...swole.Attribute) {
+	span := trace.SpanFromContext(ctx)
+
+	index := slices.IndexFunc(attributes, func(a swole.Attribute) bool { return a.Key == swole.AttributeExperiment })
+	if index < 0 {
+		span.SetAttributes(toAttributes(attributes)...)
+		return
+	}
+
+	prefix := swole.AttributeExperiment + "." + attributes[index].Value
+	kvs := make([]attribute.KeyValue, 0, len(attributes)-1)
+	for _, a := range attributes {
+		switch a.Key {
+		case swole.AttributeExperiment:
+		case swole.AttributeAlternative:
+			kvs = append(kvs, attribute.String(prefix, a.Value))
+		default:
+			kvs = append(kvs, attribute.String(prefix+"."+strings.TrimPrefix(a.Key, "swole."), a.Value))
+		}
+	}
+	span.SetAttributes(kvs...)
+}
+
+// Count adds the value to the counter, instruments that cannot be created are skipped
+// since telemetry must never break the experiments
+func (i *Instrumentation) Count(ctx context.Context, name string, value int64, attributes ...swole.Attribute) {
+	counter, err := i.counter(name)
+	if err != nil {
+		return
+	}
+
+	counter.Add(ctx, value, metric.WithAttributes(toAttributes(attributes)...))
+}
+
+// Record records the duration in seconds
+func (i *Instrumentation) Record(ctx context.Context, name string, duration time.Duration, attributes ...swole.Attribute) {
+	histogram, err := i.histogram(name)
+	if err != nil {
+		return
+	}
+
+	histogram.Record(ctx, duration.Seconds(), metric.WithAttributes(toAttributes(attributes)...))
+}
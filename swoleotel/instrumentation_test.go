@@ -0,0 +1,77 @@
+package swoleotel
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/antonisgkamitsios/swole"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestInstrumentation(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	meterProvider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	spans := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+
+	manager := swole.NewExperimentManager()
+	manager.Instrumentation = New(meterProvider.Meter("swole"))
+	manager.RegisterExperiment(swole.Experiment{
+		Key:          "experiment_key",
+		Alternatives: swole.Alternatives{{Name: "control"}, {Name: "variant"}},
+	})
+	manager.RegisterExperiment(swole.Experiment{
+		Key:          "other_key",
+		Alternatives: swole.Alternatives{{Name: "red"}, {Name: "blue"}},
+	})
+
+	ctx, span := tracerProvider.Tracer("test").Start(context.Background(), "request")
+	r := httptest.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	response, err := manager.StartExperiment("experiment_key", httptest.NewRecorder(), r)
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	other, err := manager.StartExperiment("other_key", httptest.NewRecorder(), r)
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	_, err = manager.StartExperiment("unknown", httptest.NewRecorder(), r)
+	if err == nil {
+		t.Fatal("expected to error but did not")
+	}
+	span.End()
+
+	attributes := make(map[string]string)
+	for _, kv := range spans.Ended()[0].Attributes() {
+		attributes[string(kv.Key)] = kv.Value.AsString()
+	}
+	// the experiments of the same span keep their own attributes
+	if attributes["swole.experiment.experiment_key"] != response.Alternative || attributes["swole.experiment.other_key"] != other.Alternative {
+		t.Errorf("expected span to be annotated with both assignments but got: %v", attributes)
+	}
+	if attributes["swole.experiment.experiment_key.first_time"] != "true" || attributes["swole.experiment.other_key.first_time"] != "true" {
+		t.Errorf("expected span to be annotated with the first time of both but got: %v", attributes)
+	}
+
+	var data metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	found := make(map[string]bool)
+	for _, scope := range data.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			found[m.Name] = true
+		}
+	}
+	for _, name := range []string{swole.MetricAssignments, swole.MetricErrors, swole.MetricStoreDuration} {
+		if !found[name] {
+			t.Errorf("expected metric %s to be recorded", name)
+		}
+	}
+}
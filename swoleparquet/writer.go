@@ -0,0 +1,68 @@
+// Package swoleparquet exports the events of swole as Parquet, for the data warehouses that
+// load it. It is a module of its own so that the core library has no dependencies
+package swoleparquet
+
+import (
+	"io"
+	"time"
+
+	"github.com/antonisgkamitsios/swole"
+	"github.com/parquet-go/parquet-go"
+)
+
+// Row is an event as it is written, the fields that an event does not carry are null
+type Row struct {
+	Type        string    `parquet:"type"`
+	Time        time.Time `parquet:"time,timestamp(microsecond)"`
+	Experiment  string    `parquet:"experiment"`
+	Alternative string    `parquet:"alternative"`
+	Subject     *string   `parquet:"subject,optional"`
+	Goal        *string   `parquet:"goal,optional"`
+	Value       *float64  `parquet:"value,optional"`
+	Failure     *string   `parquet:"failure,optional"`
+	Holdout     *string   `parquet:"holdout,optional"`
+}
+
+// EventWriter implements swole.EventWriter, the file is complete once it is flushed
+// so it must be flushed only once
+type EventWriter struct {
+	w *parquet.GenericWriter[Row]
+}
+
+var _ swole.EventWriter = (*EventWriter)(nil)
+
+func NewEventWriter(w io.Writer) *EventWriter {
+	return &EventWriter{w: parquet.NewGenericWriter[Row](w)}
+}
+
+func (e *EventWriter) Write(event swole.Event) error {
+	row := Row{
+		Type:        string(event.Type),
+		Time:        event.Time,
+		Experiment:  event.Experiment,
+		Alternative: event.Alternative,
+		Subject:     optional(event.Subject),
+		Goal:        optional(event.Goal),
+		Failure:     optional(string(event.Failure)),
+		Holdout:     optional(event.Holdout),
+	}
+	if event.Type == swole.EventValue || event.Type == swole.EventSampleRatioMismatch {
+		row.Value = &event.Value
+	}
+
+	_, err := e.w.Write([]Row{row})
+	return err
+}
+
+// Flush writes the buffered rows and the footer of the file
+func (e *EventWriter) Flush() error {
+	return e.w.Close()
+}
+
+func optional(value string) *string {
+	if len(value) == 0 {
+		return nil
+	}
+
+	return &value
+}
@@ -0,0 +1,51 @@
+package swoleparquet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antonisgkamitsios/swole"
+	"github.com/parquet-go/parquet-go"
+)
+
+const events = `{"type":"exposure","time":"2026-03-01T09:00:00Z","experiment":"checkout","alternative":"control","subject":"user_1"}
+{"type":"value","time":"2026-03-01T11:00:00Z","experiment":"checkout","alternative":"variant","subject":"user_2","goal":"order","value":42.5}
+{"type":"failure","time":"2026-03-01T12:00:00Z","experiment":"checkout","alternative":"control","failure":"fail_open"}
+`
+
+func TestEventWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewEventWriter(&buf)
+
+	exported, err := swole.ExportEvents(strings.NewReader(events), swole.EventFilter{}, w)
+	if err != nil {
+		t.Fatalf("expected not to error but got: %v", err)
+	}
+	if exported != 3 {
+		t.Errorf("expected 3 events to be exported but got: %d", exported)
+	}
+
+	rows, err := parquet.Read[Row](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("expected to read the file but got: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("expected 3 rows but got: %+v", rows)
+	}
+
+	exposure, value, failure := rows[0], rows[1], rows[2]
+	if exposure.Type != "exposure" || !exposure.Time.Equal(time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)) || *exposure.Subject != "user_1" {
+		t.Errorf("unexpected exposure row: %+v", exposure)
+	}
+	if exposure.Value != nil || exposure.Goal != nil || exposure.Failure != nil {
+		t.Errorf("expected the exposure not to carry a value, a goal or a failure but got: %+v", exposure)
+	}
+	if value.Value == nil || *value.Value != 42.5 || *value.Goal != "order" {
+		t.Errorf("unexpected value row: %+v", value)
+	}
+	if failure.Subject != nil || failure.Failure == nil || *failure.Failure != string(swole.FailOpen) {
+		t.Errorf("unexpected failure row: %+v", failure)
+	}
+}
@@ -0,0 +1,33 @@
+package swole
+
+// AlternativeCounts holds what was tracked for a single alternative of an experiment
+type AlternativeCounts struct {
+	Participants int
+	Completions  int
+}
+
+// TrackingStore keeps count of the participants and completions of the experiments.
+// A store can be shared by many instances of the application, in that case the
+// implementation must make sure that AddParticipant is atomic across all of them
+type TrackingStore interface {
+	// AddParticipant records a new participant of the alternative. When limit is positive the
+	// participant is recorded only if the experiment has less than limit participants in total
+	AddParticipant(key, alternative string, limit int) (added bool, err error)
+	// RemoveParticipant takes back a participant that was added but could not be enrolled,
+	// for example because its assignment could not be persisted
+	RemoveParticipant(key, alternative string) (err error)
+	AddCompletion(key, alternative string) (err error)
+	// AddValue records a numeric value of a goal, like the value of an order. The values are
+	// needed to compute variances and confidence intervals, a store may keep a uniform sample of
+	// them to bound its storage, like MemoryTrackingStore does
+	AddValue(key, alternative, goal string, value float64) (err error)
+	// AddExclusion records a start of a participant that was not enrolled in the experiment.
+	// Excluded participants are not persisted, so it is called on every start
+	AddExclusion(key string, reason ExclusionReason) (err error)
+	// Counts returns the counts of the experiment keyed by alternative
+	Counts(key string) (counts map[string]AlternativeCounts, err error)
+	// Exclusions returns the number of starts of participants that were not enrolled keyed by reason
+	Exclusions(key string) (exclusions map[ExclusionReason]int, err error)
+	// Values returns the values of the goal, or a uniform sample of them, keyed by alternative
+	Values(key, goal string) (values map[string][]float64, err error)
+}
@@ -0,0 +1,88 @@
+package swole
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// decodePayload strictly decodes a payload, unknown fields are rejected so that typos in
+// the configuration are caught when the experiment is registered
+func decodePayload[T any](payload json.RawMessage) (T, error) {
+	var value T
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&value)
+	if err != nil {
+		return value, err
+	}
+
+	if decoder.More() {
+		return value, fmt.Errorf("unexpected data after the payload")
+	}
+
+	return value, nil
+}
+
+// checkPayloads checks that every served alternative carries a payload of type T, the ones
+// of a multivariate experiment are the combinations of its factors
+func checkPayloads[T any](experiment Experiment) error {
+	for _, a := range experiment.served().Alternatives {
+		if len(a.Payload) == 0 {
+			return &InvalidExperimentError{
+				message: fmt.Sprintf("alternative `%s` has no payload", a.Name),
+				key:     experiment.Key,
+			}
+		}
+
+		_, err := decodePayload[T](a.Payload)
+		if err != nil {
+			return &InvalidExperimentError{
+				message: fmt.Sprintf("payload of alternative `%s` does not match %T: %v", a.Name, *new(T), err),
+				key:     experiment.Key,
+			}
+		}
+	}
+
+	return nil
+}
+
+// RegisterTypedExperiment registers an experiment whose alternatives all carry a payload of type T.
+// The payloads of the experiment loaded from the ExperimentStore in its place are checked too,
+// see ReloadExperiments
+func RegisterTypedExperiment[T any](m *ExperimentManager, experiment Experiment) error {
+	err := checkPayloads[T](experiment)
+	if err != nil {
+		panic(err)
+	}
+
+	err = m.RegisterExperiment(experiment)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.payloadChecks[experiment.Key] = checkPayloads[T]
+
+	return nil
+}
+
+// StartTypedExperiment starts the experiment and returns the payload of the served alternative
+func StartTypedExperiment[T any](m *ExperimentManager, key string, w http.ResponseWriter, r *http.Request) (T, *StartExperimentResponse, error) {
+	var value T
+
+	response, err := m.StartExperiment(key, w, r)
+	if err != nil {
+		return value, nil, err
+	}
+
+	value, err = decodePayload[T](response.Payload)
+	if err != nil {
+		return value, nil, err
+	}
+
+	return value, response, nil
+}
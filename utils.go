@@ -1,13 +1,22 @@
 package swole
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"hash/fnv"
 	"net/http"
 	"net/url"
 )
 
 var (
-	ErrValueTooLong = errors.New("cookie value too long")
+	ErrValueTooLong       = errors.New("cookie value too long")
+	ErrMissingSubject     = errors.New("subject cannot be empty")
+	ErrAssignmentNotFound = errors.New("assignment not found")
+	ErrNoExperimentStore  = errors.New("no experiment store configured")
+	ErrMissingGoal        = errors.New("goal cannot be empty")
+	ErrInvalidValue       = errors.New("value must be a finite number")
+	ErrMissingHTTP        = errors.New("context does not carry an http request, see WithHTTP")
 )
 
 func unique[T comparable](values []T) bool {
@@ -27,6 +36,18 @@ func writeCookie(w http.ResponseWriter, cookie http.Cookie) error {
 		return ErrValueTooLong
 	}
 
+	// a cookie written earlier while handling the same request is replaced
+	// so that the response carries only the latest value
+	header := w.Header()
+	setCookies := header.Values("Set-Cookie")
+	header.Del("Set-Cookie")
+	for _, c := range setCookies {
+		if parsed, err := http.ParseSetCookie(c); err == nil && parsed.Name == cookie.Name {
+			continue
+		}
+		header.Add("Set-Cookie", c)
+	}
+
 	http.SetCookie(w, &cookie)
 	return nil
 }
@@ -45,3 +66,36 @@ func readCookie(r *http.Request, cookieName string) (*http.Cookie, error) {
 
 	return cookie, nil
 }
+
+// readResponseCookie looks for a cookie that was already set on the response
+func readResponseCookie(w http.ResponseWriter, cookieName string) *http.Cookie {
+	res := http.Response{Header: w.Header()}
+	for _, c := range res.Cookies() {
+		if c.Name == cookieName {
+			return c
+		}
+	}
+
+	return nil
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// bucket deterministically maps an id to one of the given number of buckets,
+// the seed makes sure that the same id lands on unrelated buckets for different seeds
+func bucket(seed, id string, buckets int) int {
+	h := fnv.New64a()
+	h.Write([]byte(seed))
+	h.Write([]byte{':'})
+	h.Write([]byte(id))
+
+	return int(h.Sum64() % uint64(buckets))
+}
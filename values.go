@@ -0,0 +1,344 @@
+package swole
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"slices"
+)
+
+// ValueParams configures the analysis of the numeric values of a goal
+type ValueParams struct {
+	// CapPercentile caps the values above this percentile of all the values of the goal,
+	// 0.99 limits the influence of a few very large orders. Zero disables it
+	CapPercentile float64
+	// Cap caps the values above it, zero disables it. When both caps are set the lowest one is used
+	Cap float64
+	// Alpha is the significance level of the tests and the confidence intervals, zero means 0.05
+	Alpha float64
+	// BootstrapSamples is the number of resamples of the confidence intervals, zero means 1000
+	BootstrapSamples int
+	// Seed makes the confidence intervals reproducible
+	Seed uint64
+}
+
+type ValueAlternativeResult struct {
+	Name     string  `json:"name"`
+	Count    int     `json:"count"`
+	Mean     float64 `json:"mean"`
+	Variance float64 `json:"variance"`
+	// ConfidenceLow and ConfidenceHigh bound the mean, they are computed by bootstrapping. Past
+	// 10000 values the normal approximation is used instead, it is accurate at that size and
+	// resampling would be too slow
+	ConfidenceLow  float64 `json:"confidence_low"`
+	ConfidenceHigh float64 `json:"confidence_high"`
+	// Difference, TStatistic, DegreesOfFreedom and PValue compare the alternative with the
+	// control using Welch's t-test, they are zero for the control
+	Difference       float64 `json:"difference"`
+	TStatistic       float64 `json:"t_statistic"`
+	DegreesOfFreedom float64 `json:"degrees_of_freedom"`
+	PValue           float64 `json:"p_value"`
+	Significant      bool    `json:"significant"`
+}
+
+type ValueResults struct {
+	Key  string `json:"key"`
+	Goal string `json:"goal"`
+	// Cap is the cap that was applied to the values, zero when they were not capped
+	Cap float64 `json:"cap,omitempty"`
+	// Alternatives are in the order they were registered, the first one is the control
+	Alternatives []ValueAlternativeResult `json:"alternatives"`
+}
+
+// GetValueResults analyses the values recorded for the goal of the experiment
+func (m *ExperimentManager) GetValueResults(key, goal string, params ValueParams) (*ValueResults, error) {
+	experiment, found := m.getExperiment(key)
+	if !found {
+		return nil, &ExperimentNotFoundError{
+			key:     key,
+			message: "GetValueResults failed, make sure you called `RegisterExperiment` first",
+		}
+	}
+
+	values, err := m.TrackingStore.Values(key, goal)
+	if err != nil {
+		return nil, err
+	}
+
+	return AnalyzeValues(experiment, goal, values, params)
+}
+
+// valueCollector collects the values of a goal from events, except the ones of held out participants
+type valueCollector struct {
+	goal   string
+	values map[string][]float64
+}
+
+func (c *valueCollector) Write(event Event) error {
+	if event.Goal == c.goal && len(event.Holdout) == 0 {
+		c.values[event.Alternative] = append(c.values[event.Alternative], event.Value)
+	}
+
+	return nil
+}
+
+func (c *valueCollector) Flush() error {
+	return nil
+}
+
+// ValueResultsFromEvents analyses the values of the goal from the events written by a JSONLinesEventSink
+func ValueResultsFromEvents(r io.Reader, experiment Experiment, goal string, params ValueParams) (*ValueResults, error) {
+	collector := &valueCollector{goal: goal, values: make(map[string][]float64)}
+
+	_, err := ExportEvents(r, EventFilter{Experiment: experiment.Key, Types: []EventType{EventValue}}, collector)
+	if err != nil {
+		return nil, err
+	}
+
+	return AnalyzeValues(experiment, goal, collector.values, params)
+}
+
+// AnalyzeValues computes the mean, the variance and a bootstrap confidence interval of the values
+// of every alternative, and compares every alternative with the control using Welch's t-test
+func AnalyzeValues(experiment Experiment, goal string, values map[string][]float64, params ValueParams) (*ValueResults, error) {
+	experiment = experiment.served()
+	if params.Alpha == 0 {
+		params.Alpha = 0.05
+	}
+	if params.BootstrapSamples == 0 {
+		params.BootstrapSamples = 1000
+	}
+
+	switch {
+	case params.Alpha <= 0 || params.Alpha >= 1:
+		return nil, fmt.Errorf("%w: alpha must be between 0 and 1", ErrInvalidParameter)
+	case params.CapPercentile < 0 || params.CapPercentile >= 1:
+		return nil, fmt.Errorf("%w: cap percentile must be between 0 and 1", ErrInvalidParameter)
+	case params.Cap < 0:
+		return nil, fmt.Errorf("%w: cap must be positive", ErrInvalidParameter)
+	case params.BootstrapSamples < 0:
+		return nil, fmt.Errorf("%w: bootstrap samples must be positive", ErrInvalidParameter)
+	case len(experiment.Alternatives) < 2:
+		return nil, fmt.Errorf("%w: at least 2 alternatives are needed", ErrInvalidParameter)
+	}
+
+	results := &ValueResults{
+		Key:          experiment.Key,
+		Goal:         goal,
+		Cap:          valueCap(values, params),
+		Alternatives: make([]ValueAlternativeResult, 0, len(experiment.Alternatives)),
+	}
+
+	random := rand.New(rand.NewPCG(params.Seed, params.Seed))
+	for _, a := range experiment.Alternatives {
+		v := slices.Clone(values[a.Name])
+		if results.Cap > 0 {
+			for i := range v {
+				v[i] = min(v[i], results.Cap)
+			}
+		}
+
+		mean, variance := meanVariance(v)
+		low, high := meanInterval(v, mean, variance, params, random)
+		results.Alternatives = append(results.Alternatives, ValueAlternativeResult{
+			Name:           a.Name,
+			Count:          len(v),
+			Mean:           mean,
+			Variance:       variance,
+			ConfidenceLow:  low,
+			ConfidenceHigh: high,
+		})
+	}
+
+	control := results.Alternatives[0]
+	for i := 1; i < len(results.Alternatives); i++ {
+		a := &results.Alternatives[i]
+		a.Difference = a.Mean - control.Mean
+		a.TStatistic, a.DegreesOfFreedom, a.PValue = welchTest(control, *a)
+		a.Significant = a.PValue < params.Alpha
+	}
+
+	return results, nil
+}
+
+// valueCap returns the lowest of the configured caps, the percentile is taken over the values
+// of every alternative so that all of them are capped the same way
+func valueCap(values map[string][]float64, params ValueParams) float64 {
+	limit := params.Cap
+	if params.CapPercentile == 0 {
+		return limit
+	}
+
+	var all []float64
+	for _, v := range values {
+		all = append(all, v...)
+	}
+	if len(all) == 0 {
+		return limit
+	}
+
+	percentile := quantile(all, params.CapPercentile)
+	if limit == 0 || percentile < limit {
+		return percentile
+	}
+
+	return limit
+}
+
+// quantile returns the q quantile of the values using the nearest rank, it sorts the values
+func quantile(values []float64, q float64) float64 {
+	slices.Sort(values)
+	rank := int(math.Ceil(q*float64(len(values)))) - 1
+
+	return values[max(rank, 0)]
+}
+
+// meanVariance returns the mean and the unbiased sample variance
+func meanVariance(values []float64) (mean, variance float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+
+	if len(values) < 2 {
+		return mean, 0
+	}
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= float64(len(values) - 1)
+
+	return mean, variance
+}
+
+// maxBootstrapValues is the number of values above which the confidence interval of the mean
+// is not bootstrapped, resampling costs BootstrapSamples times the number of values
+const maxBootstrapValues = 10000
+
+// meanInterval returns the confidence interval of the mean, bootstrapped unless there are too many values
+func meanInterval(values []float64, mean, variance float64, params ValueParams, random *rand.Rand) (low, high float64) {
+	if len(values) <= maxBootstrapValues {
+		return bootstrapMean(values, params.BootstrapSamples, params.Alpha, random)
+	}
+
+	margin := normalQuantile(1-params.Alpha/2) * math.Sqrt(variance/float64(len(values)))
+
+	return mean - margin, mean + margin
+}
+
+// bootstrapMean returns the percentile bootstrap confidence interval of the mean
+func bootstrapMean(values []float64, samples int, alpha float64, random *rand.Rand) (low, high float64) {
+	if len(values) == 0 || samples == 0 {
+		return 0, 0
+	}
+
+	means := make([]float64, samples)
+	for i := range means {
+		sum := 0.0
+		for range values {
+			sum += values[random.IntN(len(values))]
+		}
+		means[i] = sum / float64(len(values))
+	}
+
+	return quantile(means, alpha/2), quantile(means, 1-alpha/2)
+}
+
+// welchTest compares the means of two samples that may have different variances
+func welchTest(control, alternative ValueAlternativeResult) (t, df, p float64) {
+	if control.Count < 2 || alternative.Count < 2 {
+		return 0, 0, 1
+	}
+
+	v1 := control.Variance / float64(control.Count)
+	v2 := alternative.Variance / float64(alternative.Count)
+	if v1+v2 == 0 {
+		return 0, 0, 1
+	}
+
+	t = (alternative.Mean - control.Mean) / math.Sqrt(v1+v2)
+	df = (v1 + v2) * (v1 + v2) / (v1*v1/float64(control.Count-1) + v2*v2/float64(alternative.Count-1))
+
+	// the two sided p-value of the Student's t distribution
+	p = regularizedIncompleteBeta(df/(df+t*t), df/2, 0.5)
+
+	return t, df, p
+}
+
+// regularizedIncompleteBeta evaluates I_x(a, b) with the continued fraction of Numerical Recipes
+func regularizedIncompleteBeta(x, a, b float64) float64 {
+	switch {
+	case x <= 0:
+		return 0
+	case x >= 1:
+		return 1
+	}
+
+	lbetaA, _ := math.Lgamma(a)
+	lbetaB, _ := math.Lgamma(b)
+	lbetaAB, _ := math.Lgamma(a + b)
+	front := math.Exp(lbetaAB - lbetaA - lbetaB + a*math.Log(x) + b*math.Log(1-x))
+
+	// the continued fraction converges quickly only below this point, use the symmetry otherwise
+	if x > (a+1)/(a+b+2) {
+		return 1 - front*betaContinuedFraction(1-x, b, a)/b
+	}
+
+	return front * betaContinuedFraction(x, a, b) / a
+}
+
+func betaContinuedFraction(x, a, b float64) float64 {
+	const (
+		iterations = 300
+		epsilon    = 1e-14
+		tiny       = 1e-300
+	)
+
+	c := 1.0
+	d := 1 - (a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+
+	for m := 1; m <= iterations; m++ {
+		m := float64(m)
+
+		numerator := m * (b - m) * x / ((a + 2*m - 1) * (a + 2*m))
+		d = 1 + numerator*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + numerator/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		h *= d * c
+
+		numerator = -(a + m) * (a + b + m) * x / ((a + 2*m) * (a + 2*m + 1))
+		d = 1 + numerator*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + numerator/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+
+		if math.Abs(delta-1) < epsilon {
+			break
+		}
+	}
+
+	return h
+}